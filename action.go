@@ -2,7 +2,11 @@
 
 package ogo
 
-import ()
+import (
+	"net/http"
+
+	"github.com/Odinman/ogo/utils"
+)
 
 type ActionInterface interface {
 	PreGet(i interface{}) (interface{}, error)  //获取前
@@ -21,6 +25,10 @@ type ActionInterface interface {
 	OnUpdate(i interface{}) (interface{}, error)   // 更新前的检查
 	PostUpdate(i interface{}) (interface{}, error) // 更新后的操作
 
+	PreReplace(i interface{}) (interface{}, error)  // 替换前的检查
+	OnReplace(i interface{}) (interface{}, error)   // 替换(全量更新)
+	PostReplace(i interface{}) (interface{}, error) // 替换后的操作
+
 	PreDelete(i interface{}) (interface{}, error)  // 删除前的检查
 	OnDelete(i interface{}) (interface{}, error)   // 删除前的检查
 	PostDelete(i interface{}) (interface{}, error) // 删除后的检查
@@ -66,12 +74,23 @@ func (_ *Router) PreGet(i interface{}) (interface{}, error) {
 		m.SetConditions(NewCondition(CTYPE_IS, TAG_TIMERANGE, tr.(*TimeRange)))
 	}
 	if cons := c.GetEnv(ConditionsKey); cons != nil { //从context里面获取参数条件
-		m.SetConditions(cons.([]*Condition)...)
+		if fe := CheckConditions(m, cons.([]*Condition)); fe != nil {
+			return nil, fe
+		}
+		if _, err := m.SetConditions(cons.([]*Condition)...); err != nil {
+			return nil, err
+		}
 	}
 	// fields
 	if fs := c.GetEnv(FieldsKey); fs != nil { //从context里面获取参数条件
 		m.SetFields(fs.([]string))
 	}
+	// 关联
+	if es, ok := c.GetEnv(ExpandKey).([]string); ok {
+		if fe := CheckExpand(m, es); fe != nil {
+			return nil, fe
+		}
+	}
 	return i, nil
 }
 
@@ -81,7 +100,16 @@ func (_ *Router) PreGet(i interface{}) (interface{}, error) {
  */
 func (_ *Router) OnGet(i interface{}) (interface{}, error) {
 	m := i.(Model)
-	return m.GetRow(m)
+	r, err := m.GetRow(m)
+	if err != nil {
+		return nil, err
+	}
+	if es, ok := m.GetCtx().GetEnv(ExpandKey).([]string); ok {
+		if err := Expand(m, r, es); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
 }
 
 /* }}} */
@@ -106,19 +134,39 @@ func (_ *Router) PreSearch(i interface{}) (interface{}, error) {
 	if p := c.GetEnv(PaginationKey); p != nil { //排序
 		m.SetPagination(p.(*Pagination))
 	}
-	if ob := c.GetEnv(OrderByKey); ob != nil { //排序
-		m.SetConditions(NewCondition(CTYPE_ORDER, TAG_ORDERBY, ob.(*OrderBy)))
+	// 条件以及排序字段检查
+	var cs []*Condition
+	var obs []*OrderBy
+	if cons := c.GetEnv(ConditionsKey); cons != nil {
+		cs = cons.([]*Condition)
+	}
+	if o := c.GetEnv(OrderByKey); o != nil {
+		obs = o.([]*OrderBy)
+	}
+	if fe := CheckConditions(m, cs, obs...); fe != nil {
+		return nil, fe
+	}
+	if len(obs) > 0 { //排序
+		m.SetConditions(NewCondition(CTYPE_ORDER, TAG_ORDERBY, obs))
 	}
 	if tr := c.GetEnv(TimeRangeKey); tr != nil { //时间段参数
 		m.SetConditions(NewCondition(CTYPE_RANGE, TAG_TIMERANGE, tr.(*TimeRange)))
 	}
-	if cons := c.GetEnv(ConditionsKey); cons != nil { //从context里面获取参数条件
-		m.SetConditions(cons.([]*Condition)...)
+	if len(cs) > 0 { //从context里面获取参数条件
+		if _, err := m.SetConditions(cs...); err != nil {
+			return nil, err
+		}
 	}
 	// fields
 	if fs := c.GetEnv(FieldsKey); fs != nil { //从context里面获取参数条件
 		m.SetFields(fs.([]string))
 	}
+	// 关联
+	if es, ok := c.GetEnv(ExpandKey).([]string); ok {
+		if fe := CheckExpand(m, es); fe != nil {
+			return nil, fe
+		}
+	}
 	return i, nil
 }
 
@@ -128,7 +176,16 @@ func (_ *Router) PreSearch(i interface{}) (interface{}, error) {
  */
 func (_ *Router) OnSearch(i interface{}) (interface{}, error) {
 	m := i.(Model)
-	return m.GetRows()
+	l, err := m.GetRows()
+	if err != nil {
+		return l, err
+	}
+	if es, ok := m.GetCtx().GetEnv(ExpandKey).([]string); ok {
+		if err := Expand(m, l, es); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
 }
 
 /* }}} */
@@ -224,6 +281,79 @@ func (_ *Router) PostUpdate(i interface{}) (interface{}, error) {
 
 /* }}} */
 
+/* {{{ func (_ *Router) PreReplace(i interface{}) (interface{}, error)
+ * 记录不存在并且不能创建的直接返回ErrNoRecord(404), 不用校验
+ */
+func (_ *Router) PreReplace(i interface{}) (interface{}, error) {
+	m := i.(Model)
+	if c := m.GetCtx(); m.GetOlder() == nil && c.Route.Options.Get(KEY_UPSERT) != true {
+		return nil, ErrNoRecord
+	}
+	var err error
+	if m, err = m.Valid(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+/* }}} */
+/* {{{ func (_ *Router) OnReplace(i interface{}) (interface{}, error)
+ * 记录不存在时, 路由选项KEY_UPSERT为true则创建(201), 否则404
+ */
+func (_ *Router) OnReplace(i interface{}) (interface{}, error) {
+	m := i.(Model)
+	c := m.GetCtx()
+	rk := c.URLParams[RowkeyKey]
+	if m.GetOlder() == nil {
+		if c.Route.Options.Get(KEY_UPSERT) != true {
+			return nil, ErrNoRecord
+		}
+		// 读不到但是存在(属于别的父记录, 或者已经逻辑删除), 不能创建
+		if em, ok := m.(interface {
+			rowExists(id string) (bool, error)
+		}); ok {
+			if exists, err := em.rowExists(rk); err != nil {
+				return nil, err
+			} else if exists {
+				return nil, ErrNoRecord
+			}
+		}
+		if err := utils.ImportValue(m, map[string]string{DBTAG_PK: rk}); err != nil {
+			return nil, err
+		}
+		r, err := m.CreateRow()
+		if err != nil {
+			return nil, err
+		}
+		c.AppLoggingNew(r)
+		c.SetStatus(http.StatusCreated)
+		return r, nil
+	}
+	rm, ok := m.(ReplaceModel)
+	if !ok {
+		return nil, ErrNoReplace
+	}
+	c.AppLoggingNew(m)
+	if affected, err := rm.ReplaceRow(rk); err != nil {
+		return nil, err
+	} else if affected <= 0 {
+		c.Info("OnReplace not affected any record")
+	}
+	return m, nil
+}
+
+/* }}} */
+/* {{{ func (_ *Router) PostReplace(i interface{}) (interface{}, error)
+ *
+ */
+func (_ *Router) PostReplace(i interface{}) (interface{}, error) {
+	m := i.(Model)
+	return m.Filter()
+}
+
+/* }}} */
+
 /* {{{ func (_ *Router) PreDelete(i interface{}) (interface{}, error)
  *
  */
@@ -270,7 +400,12 @@ func (_ *Router) PreCheck(i interface{}) (interface{}, error) {
 		m.SetConditions(NewCondition(CTYPE_IS, TAG_TIMERANGE, tr.(*TimeRange)))
 	}
 	if cons := c.GetEnv(ConditionsKey); cons != nil { //从context里面获取参数条件
-		m.SetConditions(cons.([]*Condition)...)
+		if fe := CheckConditions(m, cons.([]*Condition)); fe != nil {
+			return nil, fe
+		}
+		if _, err := m.SetConditions(cons.([]*Condition)...); err != nil {
+			return nil, err
+		}
 	}
 	return i, nil
 }
@@ -74,6 +74,9 @@ func (rc *RESTContext) SetEnv(k string, v interface{}) {
  * 设置环境变量
  */
 func (rc *RESTContext) GetEnv(k string) (v interface{}) {
+	if rc == nil { //没有请求上下文(比如worker)
+		return nil
+	}
 	var ok bool
 	if v, ok = rc.Env[k]; ok {
 		return v
@@ -0,0 +1,248 @@
+/* 批量请求, POST /{endpoint}/@batch, 多个create/update/delete在同一个事务里执行 */
+package ogo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	BATCH_CREATE = "create"
+	BATCH_UPDATE = "update"
+	BATCH_DELETE = "delete"
+
+	_BATCH_PATH    = "@batch"
+	_BATCH_MAX_OPS = 100
+)
+
+// 批量中的一个操作
+type BatchOp struct {
+	Op      string          `json:"op"`                 // create/update/delete
+	ID      string          `json:"id,omitempty"`       // update/delete的记录id
+	IfMatch string          `json:"if_match,omitempty"` // 同If-Match头
+	Body    json.RawMessage `json:"body,omitempty"`     // create/update的内容
+}
+
+// 外层请求(路径/请求头/参数)解析出来的, 不带给批量中的操作; 身份等其余的照样共用
+var batchClearKeys = []string{
+	RowkeyKey, SelectorKey, IfMatchKey, ContentMD5Key, MimeTypeKey, DispositionMTKey,
+	PaginationKey, FieldsKey, TimeRangeKey, OrderByKey, ConditionsKey, ExpandKey, rcHolderKey,
+}
+
+// 一个操作的结果
+type BatchResult struct {
+	Status int             `json:"status"`
+	ETag   string          `json:"etag,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// 收集单个操作的输出
+type batchWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *batchWriter) Header() http.Header         { return w.header }
+func (w *batchWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+func (w *batchWriter) WriteHeader(status int)      { w.status = status }
+
+/* {{{ func (rc *RESTContext) subContext(method, path, id string, op *BatchOp, rt *Route, w http.ResponseWriter) *RESTContext
+ * 为批量中的操作生成请求上下文, 共用外层的事务和环境(复制一份, 去掉外层请求解析出来的条件/分页/字段等)
+ */
+func (rc *RESTContext) subContext(method, path, id string, op *BatchOp, rt *Route, w http.ResponseWriter) *RESTContext {
+	env := make(map[interface{}]interface{}, len(rc.Env))
+	for k, v := range rc.Env {
+		if ks, ok := k.(string); ok && strings.HasPrefix(ks, DispositionPrefix) {
+			continue
+		}
+		env[k] = v
+	}
+	for _, k := range batchClearKeys {
+		delete(env, k)
+	}
+	if op.IfMatch != "" {
+		env[IfMatchKey] = op.IfMatch
+	}
+	params := map[string]string{}
+	if id != "" {
+		params[RowkeyKey] = id
+		env[RowkeyKey] = id
+	}
+
+	r := new(http.Request)
+	*r = *rc.Request
+	r.Method = method
+	r.Header = make(http.Header)
+	for k, v := range rc.Request.Header {
+		switch k {
+		case "Accept-Encoding", ifMatch, ifNoneMatch, ifModifiedSince: //这些只对外层请求有效
+		default:
+			r.Header[k] = v
+		}
+	}
+	u := *rc.Request.URL
+	u.Path, u.RawQuery = path, ""
+	r.URL = &u
+
+	return &RESTContext{
+		C:           web.C{URLParams: params, Env: env},
+		Response:    w,
+		Request:     r,
+		RequestBody: op.Body,
+		Accept:      ContentTypeJSON,
+		Version:     rc.Version,
+		OTP:         rc.OTP,
+		Access:      rc.Access,
+		Route:       rt,
+		App:         rc.App,
+		tx:          rc.Tx(),
+		sharedTx:    true,
+	}
+}
+
+/* }}} */
+
+/* {{{ func (rc *RESTContext) adopt(sc *RESTContext, tasks bool)
+ * 批量操作获取的锁(以及任务)交给外层请求, 外层结束(事务提交)后统一释放(执行)
+ */
+func (rc *RESTContext) adopt(sc *RESTContext, tasks bool) {
+	for key, lk := range sc.locks {
+		if rc.locks == nil {
+			rc.locks = make(map[string]*Lock)
+		}
+		if _, ok := rc.locks[key]; ok { //外层已经持有
+			if err := lk.Release(); err != nil {
+				rc.Info("release lock(%s) error: %s", key, err)
+			}
+			continue
+		}
+		rc.locks[key] = lk
+	}
+	sc.locks = nil
+	if tasks && len(sc.tasks) > 0 {
+		rc.tasks = append(rc.tasks, sc.tasks...)
+	}
+	sc.tasks = nil
+}
+
+/* }}} */
+
+/* {{{ func (rtr *Router) Batch(i interface{}, flag int) Handler
+ * 批量操作, 每个操作走和单独请求一样的Pre/On/Post流程
+ * 全部成功则提交; 有一个失败则回滚, 其余操作标记为424
+ * 返回207, 内容为每个操作的结果
+ */
+func (rtr *Router) Batch(i interface{}, flag int) Handler {
+	endpoint := rtr.GetEndpoint()
+	type action struct {
+		flag   int
+		method string
+		rt     *Route
+	}
+	actions := map[string]*action{
+		BATCH_CREATE: {flag: GA_POST, method: "POST"},
+		BATCH_UPDATE: {flag: GA_PATCH, method: "PATCH"},
+		BATCH_DELETE: {flag: GA_DELETE, method: "DELETE"},
+	}
+	for _, a := range actions {
+		p := "/" + endpoint
+		if a.flag != GA_POST {
+			p += "/:" + RowkeyKey
+		}
+		a.rt = NewRoute(p, endpoint, a.method, rtr.CRUD(i, a.flag))
+		a.rt.router = rtr
+	}
+	// 优先用注册的路由(选项, 中间件等和单独请求一样), 路由在处理请求之前都已经注册
+	route := func(a *action) *Route {
+		if rt, ok := rtr.Routes[a.rt.Key]; ok {
+			return rt
+		}
+		return a.rt
+	}
+
+	return func(c *RESTContext) {
+		ops := make([]*BatchOp, 0)
+		if err := json.Unmarshal(c.RequestBody, &ops); err != nil {
+			c.RESTBadRequest(fmt.Errorf("invalid batch body: %s", err))
+			return
+		} else if len(ops) == 0 {
+			c.RESTBadRequest(fmt.Errorf("empty batch"))
+			return
+		} else if len(ops) > _BATCH_MAX_OPS {
+			c.RESTBadRequest(fmt.Errorf("too many operations, max %d", _BATCH_MAX_OPS))
+			return
+		}
+
+		results := make([]*BatchResult, len(ops))
+		scs := make([]*RESTContext, 0, len(ops))
+		failed := -1
+		for n, op := range ops {
+			a, ok := actions[strings.ToLower(op.Op)]
+			if !ok || flag&a.flag == 0 {
+				results[n] = &BatchResult{Status: http.StatusMethodNotAllowed}
+				results[n].Body, _ = json.Marshal(c.NewRESTError(http.StatusMethodNotAllowed, fmt.Sprintf("unsupported op: %s", op.Op)))
+				failed = n
+				break
+			}
+			if a.flag != GA_POST && op.ID == "" {
+				results[n] = &BatchResult{Status: http.StatusBadRequest}
+				results[n].Body, _ = json.Marshal(c.NewRESTError(http.StatusBadRequest, FieldErrors{"id": "required"}))
+				failed = n
+				break
+			}
+			path := "/" + endpoint
+			if op.ID != "" {
+				path += "/" + url.PathEscape(op.ID)
+			}
+			w := &batchWriter{header: make(http.Header)}
+			rt := route(a)
+			sc := c.subContext(a.method, path, op.ID, op, rt, w)
+			scs = append(scs, sc)
+			// pre hooks(权限等)每个操作都要执行
+			for _, hook := range DMux.Hooks.PreHooks() {
+				if err := hook(sc); err != nil {
+					sc.RESTError(err)
+					break
+				}
+			}
+			if w.status == 0 {
+				rt.handler()(sc)
+			}
+			results[n] = &BatchResult{Status: w.status, ETag: w.header.Get("ETag")}
+			if w.body.Len() > 0 {
+				results[n].Body = json.RawMessage(w.body.Bytes())
+			}
+			if w.status >= http.StatusBadRequest {
+				failed = n
+				break
+			}
+		}
+
+		for _, sc := range scs { //锁在外层结束时释放, 任务全部成功才执行
+			c.adopt(sc, failed < 0)
+		}
+		if failed >= 0 { //回滚, 其余操作都失败
+			if tx := c.Tx(); tx != nil {
+				if err := tx.Rollback(); err != nil {
+					c.Warn("rollback failed: %s", err)
+				}
+			}
+			for n := range results {
+				if n != failed {
+					results[n] = &BatchResult{Status: http.StatusFailedDependency}
+				}
+			}
+		}
+		c.SetStatus(http.StatusMultiStatus)
+		c.RESTOK(results)
+	}
+}
+
+/* }}} */
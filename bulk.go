@@ -0,0 +1,430 @@
+/* 批量操作, 多行INSERT按块执行, 块大小为配置data::bulk_size */
+package ogo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Odinman/gorp"
+	"github.com/Odinman/ogo/utils"
+)
+
+const (
+	_DEF_BULK_SIZE = 500
+)
+
+var (
+	ErrNoCondition = errors.New("refuse to update or delete without condition")
+)
+
+// 批量结果, Errors的key为传入rows的下标
+type BulkResult struct {
+	Affected int64
+	Errors   map[int]error
+}
+
+// 一行待写入的数据
+type bulkRow struct {
+	idx  int
+	m    Model
+	cols []string
+	vals []interface{}
+}
+
+/* {{{ func bulkSize() int
+ * 每条语句的最大行数
+ */
+func bulkSize() int {
+	if cfg := Config(); cfg != nil {
+		if n, err := cfg.Int("data::bulk_size"); err == nil && n > 0 {
+			return n
+		}
+	}
+	return _DEF_BULK_SIZE
+}
+
+/* }}} */
+
+/* {{{ func insertColumns(m Model) ([]string, []interface{})
+ * 要写入的字段以及值, 空的自增主键由数据库生成
+ */
+func insertColumns(m Model) ([]string, []interface{}) {
+	v := reflect.ValueOf(m)
+	_, _, ai := m.PKey()
+	cols := make([]string, 0)
+	vals := make([]interface{}, 0)
+	for _, col := range utils.ReadStructColumns(m, true) {
+		if col.Tag == "-" || col.ExtOptions.Contains(TAG_HIDDEN) {
+			continue
+		}
+		fv := utils.FieldByIndex(v, col.Index)
+		if !fv.IsValid() || !fv.CanInterface() {
+			continue
+		}
+		if col.TagOptions.Contains(DBTAG_PK) && ai && utils.IsEmptyValue(fv) {
+			continue
+		}
+		cols = append(cols, col.Tag)
+		vals = append(vals, fv.Interface())
+	}
+	return cols, vals
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) prepareRows(rows []Model, br *BulkResult) []*bulkRow
+ * 每行做和Valid相同的字段处理以及BeforeCreate, 出错的行记到br.Errors
+ */
+func (bm *BaseModel) prepareRows(rows []Model, br *BulkResult) []*bulkRow {
+	m := bm.GetModel()
+	brs := make([]*bulkRow, 0, len(rows))
+	for i, row := range rows {
+		if row == nil {
+			br.Errors[i] = fmt.Errorf("not found model")
+			continue
+		}
+		row = BuildModel(row, m.GetCtx(), bm.GetTx())
+		vm, ok := row.(interface {
+			validFields(op int) (Model, error)
+		})
+		if !ok {
+			br.Errors[i] = fmt.Errorf("model must embed BaseModel")
+			continue
+		}
+		if _, err := vm.validFields(HOOK_CREATE); err != nil {
+			br.Errors[i] = err
+			continue
+		}
+		if h, ok := row.(BeforeCreateInterface); ok {
+			if err := h.BeforeCreate(); err != nil {
+				br.Errors[i] = err
+				continue
+			}
+		}
+		cols, vals := insertColumns(row)
+		brs = append(brs, &bulkRow{idx: i, m: row, cols: cols, vals: vals})
+	}
+	return brs
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) execRows(db gorp.SqlExecutor, brs []*bulkRow, br *BulkResult, build func(cols []string, n int) string)
+ * 字段相同的连续行合成一条语句, 一条语句失败则其中所有行都记为失败
+ */
+func (bm *BaseModel) execRows(db gorp.SqlExecutor, brs []*bulkRow, br *BulkResult, build func(cols []string, n int) string) {
+	size := bulkSize()
+	d := GetDialect(bm.dbTag(WRITETAG))
+	for start := 0; start < len(brs); {
+		end := start + 1
+		sig := strings.Join(brs[start].cols, ",")
+		for end < len(brs) && end-start < size && strings.Join(brs[end].cols, ",") == sig {
+			end++
+		}
+		chunk := brs[start:end]
+		args := make([]interface{}, 0, len(chunk)*len(chunk[0].cols))
+		for _, r := range chunk {
+			args = append(args, r.vals...)
+		}
+		if res, err := db.Exec(Rebind(d, build(chunk[0].cols, len(chunk))), args...); err != nil {
+			for _, r := range chunk {
+				br.Errors[r.idx] = err
+			}
+		} else {
+			affected, _ := res.RowsAffected()
+			br.Affected += affected
+			for _, r := range chunk {
+				if h, ok := r.m.(AfterCreateInterface); ok {
+					if err := h.AfterCreate(); err != nil {
+						br.Errors[r.idx] = err
+					}
+				}
+			}
+		}
+		start = end
+	}
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) CreateRows(rows []Model) (*BulkResult, error)
+ * 批量创建, 每行的错误在BulkResult.Errors里; 自增主键不会回填
+ */
+func (bm *BaseModel) CreateRows(rows []Model) (*BulkResult, error) {
+	m := bm.GetModel()
+	if m == nil {
+		return nil, fmt.Errorf("not found model")
+	}
+	db, err := bm.Executor(WRITETAG)
+	if err != nil {
+		return nil, err
+	}
+	br := &BulkResult{Errors: make(map[int]error)}
+	d := GetDialect(bm.dbTag(WRITETAG))
+	tb := m.TableName()
+	bm.execRows(db, bm.prepareRows(rows, br), br, func(cols []string, n int) string {
+		return upsertValues(d, tb, cols, n)
+	})
+	return br, nil
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) UpsertRows(rows []Model, updates ...string) (*BulkResult, error)
+ * 批量插入, 主键冲突时更新updates指定的字段(不指定则更新所有字段)
+ * mysql的affected: 插入为1, 更新为2
+ */
+func (bm *BaseModel) UpsertRows(rows []Model, updates ...string) (*BulkResult, error) {
+	m := bm.GetModel()
+	if m == nil {
+		return nil, fmt.Errorf("not found model")
+	}
+	db, err := bm.Executor(WRITETAG)
+	if err != nil {
+		return nil, err
+	}
+	br := &BulkResult{Errors: make(map[int]error)}
+	pf, _, _ := m.PKey()
+	brs := make([]*bulkRow, 0, len(rows))
+	for _, r := range bm.prepareRows(rows, br) {
+		if !utils.InSlice(pf, r.cols) {
+			br.Errors[r.idx] = FieldErrors{pf: "required"}
+			continue
+		}
+		brs = append(brs, r)
+	}
+	d := GetDialect(bm.dbTag(WRITETAG))
+	tb := m.TableName()
+	bm.execRows(db, brs, br, func(cols []string, n int) string {
+		return d.Upsert(tb, cols, []string{pf}, updates, n)
+	})
+	return br, nil
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) bulkWhere(cs []*Condition, d Dialect) (string, []interface{}, error)
+ * 批量更新/删除的条件, 没有条件或者条件无法安全处理(OR/JOIN)的都拒绝
+ */
+func (bm *BaseModel) bulkWhere(cs []*Condition, d Dialect) (string, []interface{}, error) {
+	if len(cs) == 0 {
+		cs = bm.GetConditions()
+	}
+	ws := make([]string, 0)
+	args := make([]interface{}, 0)
+	add := func(query string, as ...interface{}) {
+		ws = append(ws, query)
+		args = append(args, as...)
+	}
+	for _, v := range cs {
+		if v.Or != nil || v.Join != nil {
+			return "", nil, fmt.Errorf("unsupported condition for bulk operation: %s", v.Field)
+		}
+		switch vt := v.Range.(type) {
+		case *TimeRange:
+			add("T."+d.Quote(v.Field)+" BETWEEN ? AND ?", vt.Start, vt.End)
+		case TimeRange:
+			add("T."+d.Quote(v.Field)+" BETWEEN ? AND ?", vt.Start, vt.End)
+		}
+		v.where(add, d)
+	}
+	if len(ws) == 0 {
+		return "", nil, ErrNoCondition
+	}
+	if ps := parentScope(bm.GetModel()); ps != nil { //嵌套路由, 限定在父记录之下
+		add("T."+d.Quote(ps.Field)+" = ?", ps.Value)
+	}
+	for _, col := range utils.ReadStructColumns(bm.GetModel(), true) {
+		if col.TagOptions.Contains(DBTAG_LOGIC) { //已经逻辑删除的不动
+			add("T." + d.Quote(col.Tag) + " != -1")
+		}
+	}
+	return strings.Join(ws, " AND "), args, nil
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) bulkUpdate(sets []string, args []interface{}, cs []*Condition) (int64, error)
+ * UPDATE t AS T SET ... WHERE ..., 有版本号的同时加1
+ */
+func (bm *BaseModel) bulkUpdate(sets []string, args []interface{}, cs []*Condition) (int64, error) {
+	m := bm.GetModel()
+	d := GetDialect(bm.dbTag(WRITETAG))
+	ws, wargs, err := bm.bulkWhere(cs, d)
+	if err != nil {
+		return 0, err
+	}
+	if col, ok := versionColumn(m); ok {
+		sets = append(sets, fmt.Sprintf("%s = COALESCE(%s, 0) + 1", d.Quote(col.Tag), d.Quote(col.Tag)))
+	}
+	db, err := bm.Executor(WRITETAG)
+	if err != nil {
+		return 0, err
+	}
+	query := fmt.Sprintf("UPDATE %s AS T SET %s WHERE %s", d.Quote(m.TableName()), strings.Join(sets, ", "), ws)
+	res, err := db.Exec(Rebind(d, query), append(args, wargs...)...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) UpdateRows(set map[string]interface{}, cs ...*Condition) (int64, error)
+ * 按条件批量更新, set的key为数据库字段名; 和UpdateRow一样做校验以及tag处理(密码加密、tag钩子、父资源等)
+ * 钩子设置的其他字段一起更新; 不传条件则用model的条件
+ */
+func (bm *BaseModel) UpdateRows(set map[string]interface{}, cs ...*Condition) (int64, error) {
+	m := bm.GetModel()
+	if m == nil {
+		return 0, fmt.Errorf("not found model")
+	}
+	if len(set) == 0 {
+		return 0, fmt.Errorf("nothing to update")
+	}
+	// 值放到一个新的model里, 走和单条更新相同的处理
+	n := NewModel(m, m.GetCtx(), bm.GetTx())
+	vm, ok := n.(interface {
+		validFields(op int) (Model, error)
+		setSent(sent map[string]bool)
+	})
+	if !ok {
+		return 0, fmt.Errorf("model must embed BaseModel")
+	}
+	// 只校验要更新的字段
+	sent := make(map[string]bool)
+	for k := range set {
+		sent[k] = true
+	}
+	vm.setSent(sent)
+	v := reflect.ValueOf(n)
+	t := v.Type()
+	cols := utils.ReadStructColumns(n, true)
+	fe := make(FieldErrors)
+	for _, col := range cols {
+		sv, ok := set[col.Tag]
+		if !ok || col.Tag == "-" {
+			continue
+		}
+		// 逐行的检查(forbbiden需要旧记录)无法批量处理
+		if col.TagOptions.Contains(DBTAG_PK) || col.ExtOptions.Contains(TAG_GENERATE) || col.ExtOptions.Contains(TAG_DENY) || col.ExtTag == EXTTAG_VERSION || col.ExtTag == "forbbiden" {
+			fe[jsonName(t, col)] = ErrNonEditable.Error()
+			continue
+		}
+		if err := setFieldValue(utils.FieldByIndex(v, col.Index), col, sv); err != nil {
+			fe[jsonName(t, col)] = err.Error()
+		}
+	}
+	for k := range set {
+		if !bm.hasColumn(k) {
+			fe[k] = "unknown field"
+		}
+	}
+	if len(fe) > 0 {
+		return 0, fe
+	}
+	if _, err := vm.validFields(HOOK_UPDATE); err != nil {
+		return 0, err
+	}
+
+	d := GetDialect(bm.dbTag(WRITETAG))
+	sets := make([]string, 0, len(set))
+	args := make([]interface{}, 0, len(set))
+	for _, col := range cols {
+		if col.Tag == "-" || col.TagOptions.Contains(DBTAG_PK) || col.TagOptions.Contains(DBTAG_LOGIC) || col.ExtTag == EXTTAG_VERSION {
+			continue
+		}
+		fv := utils.FieldByIndex(v, col.Index)
+		if !fv.IsValid() || !fv.CanInterface() {
+			continue
+		}
+		if _, ok := set[col.Tag]; ok || !utils.IsEmptyValue(fv) { //传入的, 以及钩子设置的
+			sets = append(sets, d.Quote(col.Tag)+" = ?")
+			args = append(args, fv.Interface())
+		}
+	}
+	return bm.bulkUpdate(sets, args, cs)
+}
+
+/* }}} */
+
+/* {{{ func setFieldValue(fv reflect.Value, col utils.StructColumn, sv interface{}) error
+ * 把值写入字段, 类型不同的按字符串转换, nil为置空
+ */
+func setFieldValue(fv reflect.Value, col utils.StructColumn, sv interface{}) error {
+	if !fv.IsValid() || !fv.CanSet() {
+		return fmt.Errorf("field(%s) can't be set", col.Tag)
+	}
+	rv := reflect.ValueOf(sv)
+	for rv.IsValid() && rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv = reflect.Value{}
+			break
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		fv.Set(reflect.Zero(fv.Type()))
+		return nil
+	}
+	ft := fv.Type()
+	if ft.Kind() == reflect.Ptr {
+		ft = ft.Elem()
+	}
+	if rv.Type() != ft {
+		cv, err := convertValue(ft, fmt.Sprint(rv.Interface()))
+		if err != nil {
+			return err
+		}
+		if rv = reflect.ValueOf(cv); !rv.Type().ConvertibleTo(ft) {
+			return fmt.Errorf("invalid value: %v", sv)
+		}
+		rv = rv.Convert(ft)
+	}
+	if fv.Kind() == reflect.Ptr {
+		pv := reflect.New(ft)
+		pv.Elem().Set(rv)
+		fv.Set(pv)
+	} else {
+		fv.Set(rv)
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) DeleteRows(cs ...*Condition) (int64, error)
+ * 按条件批量删除(逻辑删除), 和DeleteRow一样只支持有logic字段的表
+ */
+func (bm *BaseModel) DeleteRows(cs ...*Condition) (int64, error) {
+	m := bm.GetModel()
+	if m == nil {
+		return 0, fmt.Errorf("not found model")
+	}
+	d := GetDialect(bm.dbTag(WRITETAG))
+	for _, col := range utils.ReadStructColumns(m, true) {
+		if col.TagOptions.Contains(DBTAG_LOGIC) {
+			return bm.bulkUpdate([]string{d.Quote(col.Tag) + " = -1"}, nil, cs)
+		}
+	}
+	return 0, fmt.Errorf("table %s has no logic field", m.TableName())
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) hasColumn(tag string) bool
+ *
+ */
+func (bm *BaseModel) hasColumn(tag string) bool {
+	for _, col := range utils.ReadStructColumns(bm.GetModel(), true) {
+		if col.Tag == tag && tag != "-" {
+			return true
+		}
+	}
+	return false
+}
+
+/* }}} */
@@ -0,0 +1,136 @@
+/* 条件GET, ETag/Last-Modified未变时返回304 */
+package ogo
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Odinman/ogo/utils"
+)
+
+const (
+	EXTTAG_MODIFIED = "modified" //最后修改时间字段, 作为Last-Modified
+)
+
+/* {{{ func modifiedOf(m interface{}) time.Time
+ * 记录的最后修改时间, 没有标记modified字段的返回零值
+ */
+func modifiedOf(m interface{}) time.Time {
+	for _, col := range utils.ReadStructColumns(m, true) {
+		if col.ExtTag != EXTTAG_MODIFIED {
+			continue
+		}
+		fv := utils.FieldByIndex(reflect.ValueOf(m), col.Index)
+		for fv.IsValid() && fv.Kind() == reflect.Ptr && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		if fv.IsValid() && fv.CanInterface() {
+			if t, ok := fv.Interface().(time.Time); ok {
+				return t
+			}
+		}
+		break
+	}
+	return time.Time{}
+}
+
+/* }}} */
+
+/* {{{ func LastModified(data interface{}) time.Time
+ * 输出内容的最后修改时间, 列表取所有记录中最新的
+ */
+func LastModified(data interface{}) (lm time.Time) {
+	switch d := data.(type) {
+	case Model:
+		return modifiedOf(d)
+	case *List:
+		if d == nil || d.List == nil {
+			return
+		}
+		lv := reflect.Indirect(reflect.ValueOf(d.List))
+		if lv.Kind() != reflect.Slice {
+			return
+		}
+		for i := 0; i < lv.Len(); i++ {
+			if t := modifiedOf(lv.Index(i).Interface()); t.After(lm) {
+				lm = t
+			}
+		}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (rc *RESTContext) conditional() bool
+ * 是否需要条件GET: GET/HEAD成功, 并且路由没有关闭(RouteOption{KEY_CONDITIONAL: false})
+ */
+func (rc *RESTContext) conditional() bool {
+	if rc.Status != 0 && (rc.Status < 200 || rc.Status >= 300) {
+		return false
+	}
+	if method := strings.ToUpper(rc.Request.Method); method != "GET" && method != "HEAD" {
+		return false
+	}
+	if rc.Route != nil {
+		if c, ok := rc.Route.Options.Get(KEY_CONDITIONAL).(bool); ok && !c {
+			return false
+		}
+	}
+	return true
+}
+
+/* }}} */
+
+/* {{{ func (rc *RESTContext) variantETag(etag string) string
+ * 指定了fields/expand时内容不是完整的记录, ETag加上它们的摘要: "版本号-摘要"
+ */
+func (rc *RESTContext) variantETag(etag string) string {
+	fs, _ := rc.GetEnv(FieldsKey).([]string)
+	es, _ := rc.GetEnv(ExpandKey).([]string)
+	if len(fs) == 0 && len(es) == 0 {
+		return etag
+	}
+	fs = append([]string(nil), fs...)
+	es = append([]string(nil), es...)
+	sort.Strings(fs)
+	sort.Strings(es)
+	sum := sha1.Sum([]byte(strings.Join(fs, ",") + ";" + strings.Join(es, ",")))
+	return strings.TrimSuffix(etag, `"`) + "-" + hex.EncodeToString(sum[:4]) + `"`
+}
+
+/* }}} */
+
+/* {{{ func (rc *RESTContext) notModified(content []byte, data interface{}) bool
+ * 设置ETag(已有的不覆盖, 比如版本号)以及Last-Modified, 并判断客户端缓存是否仍然有效
+ * If-None-Match优先, 没有时才看If-Modified-Since
+ */
+func (rc *RESTContext) notModified(content []byte, data interface{}) bool {
+	etag := rc.Response.Header().Get("ETag")
+	if etag == "" {
+		sum := sha1.Sum(content)
+		etag = `"` + hex.EncodeToString(sum[:]) + `"`
+		rc.SetHeader("ETag", etag)
+	}
+	lm := LastModified(data)
+	if !lm.IsZero() {
+		rc.SetHeader("Last-Modified", lm.UTC().Format(http.TimeFormat))
+	}
+
+	if inm := rc.Request.Header.Get(ifNoneMatch); inm != "" {
+		return MatchETag(inm, etag)
+	}
+	if ims := rc.Request.Header.Get(ifModifiedSince); ims != "" && !lm.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil {
+			return !lm.Truncate(time.Second).After(t)
+		}
+	}
+	return false
+}
+
+/* }}} */
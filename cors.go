@@ -0,0 +1,398 @@
+/* 跨域(CORS), 配置在[cors]中, 路由可以用RouteOption{KEY_CORS: ...}覆盖
+ * [cors]
+ * origins = https://*.example.com,http://localhost:8080  ; 为空则不开启, *为任意(返回*, 不带credentials)
+ * headers = Content-Type,Authorization                   ; 为空则允许请求中的全部
+ * expose = ETag,Last-Modified
+ * credentials = true                                     ; 只对明确配置的来源有效
+ * max_age = 600
+ * OPTIONS请求自动应答, 允许的方法为Mux.Routes中同一pattern的所有方法
+ */
+package ogo
+
+import (
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zenazn/goji/web"
+)
+
+var (
+	originHeader    = http.CanonicalHeaderKey("Origin")
+	acRequestMethod = http.CanonicalHeaderKey("Access-Control-Request-Method")
+	acRequestHeader = http.CanonicalHeaderKey("Access-Control-Request-Headers")
+
+	corsOnce    sync.Once
+	corsDefault *CORS
+)
+
+// 跨域设置
+type CORS struct {
+	Origins     []string // 允许的来源, 支持通配符(path.Match), *为任意(不带credentials)
+	Headers     []string // 允许的请求头, 为空则允许请求中的全部
+	Expose      []string // 客户端可以读取的响应头
+	Credentials bool     // 是否允许携带cookie等
+	MaxAge      int      // 预检结果缓存时间(秒)
+}
+
+/* {{{ func corsConfig() *CORS
+ * 读取[cors]配置, 没有配置origins则为nil(不开启)
+ */
+func corsConfig() *CORS {
+	corsOnce.Do(func() {
+		cfg := Config()
+		if cfg == nil {
+			return
+		}
+		cs := &CORS{
+			Origins: splitList(cfg.String("cors::origins")),
+			Headers: splitList(cfg.String("cors::headers")),
+			Expose:  splitList(cfg.String("cors::expose")),
+		}
+		if len(cs.Origins) == 0 {
+			return
+		}
+		if credentials, err := cfg.Bool("cors::credentials"); err == nil {
+			cs.Credentials = credentials
+		}
+		if cs.Credentials && cs.anyOrigin() {
+			Warn("cors: credentials are not allowed for origin *, only sent to listed origins")
+		}
+		if maxAge, err := cfg.Int("cors::max_age"); err == nil {
+			cs.MaxAge = maxAge
+		}
+		corsDefault = cs
+	})
+	return corsDefault
+}
+
+/* }}} */
+
+/* {{{ func splitList(s string) []string
+ * 逗号分隔
+ */
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+/* }}} */
+
+/* {{{ func (cs *CORS) anyOrigin() bool
+ * 是否配置了*
+ */
+func (cs *CORS) anyOrigin() bool {
+	for _, p := range cs.Origins {
+		if p == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+/* }}} */
+
+/* {{{ func (cs *CORS) allowOrigin(o string) string
+ * 返回Access-Control-Allow-Origin的值, 不允许为空
+ * 匹配明确配置的来源时返回请求的Origin, 只匹配*时返回*
+ */
+func (cs *CORS) allowOrigin(o string) string {
+	if o == "" {
+		return ""
+	}
+	for _, p := range cs.Origins {
+		if p == "*" {
+			continue
+		}
+		if strings.EqualFold(p, o) {
+			return o
+		}
+		if ok, _ := path.Match(strings.ToLower(p), strings.ToLower(o)); ok {
+			return o
+		}
+	}
+	if cs.anyOrigin() {
+		return "*"
+	}
+	return ""
+}
+
+/* }}} */
+
+/* {{{ func routeCORS(rt *Route) *CORS
+ * 路由的设置: *CORS覆盖全局, false关闭, 没有则用全局
+ */
+func routeCORS(rt *Route) *CORS {
+	if rt != nil && rt.Options != nil {
+		switch v := rt.Options.Get(KEY_CORS).(type) {
+		case *CORS:
+			return v
+		case CORS:
+			return &v
+		case bool:
+			if !v {
+				return nil
+			}
+		}
+	}
+	return corsConfig()
+}
+
+/* }}} */
+
+/* {{{ func matchPattern(p interface{}, urlPath string) bool
+ * 路由pattern是否匹配路径, 字符串pattern中":name"匹配一段, 末尾"*"匹配剩余部分
+ */
+func matchPattern(p interface{}, urlPath string) bool {
+	switch pt := p.(type) {
+	case string:
+		ps, us := strings.Split(pt, "/"), strings.Split(urlPath, "/")
+		for i, seg := range ps {
+			if seg == "*" && i == len(ps)-1 {
+				return true
+			}
+			if i >= len(us) {
+				return false
+			}
+			if strings.HasPrefix(seg, ":") {
+				if us[i] == "" {
+					return false
+				}
+			} else if seg != us[i] {
+				return false
+			}
+		}
+		return len(ps) == len(us)
+	case *regexp.Regexp:
+		return pt.MatchString(urlPath)
+	}
+	return false
+}
+
+/* }}} */
+
+// 同一pattern的路由
+type patternRoutes struct {
+	pattern interface{}
+	key     string
+	rank    int                 // 0:不含参数, 1:含":name", 2:末尾"*", 3:正则, 越小越优先
+	methods map[string][]*Route // key为方法, 不同listener可以各有一个
+}
+
+// 路由表, 不含参数的pattern直接查找, 其余按优先级依次匹配
+type routeTable struct {
+	exact  map[string]*patternRoutes
+	params []*patternRoutes
+}
+
+/* {{{ func patternRank(p interface{}) (string, int)
+ * pattern的key以及优先级
+ */
+func patternRank(p interface{}) (string, int) {
+	switch pt := p.(type) {
+	case string:
+		if strings.HasSuffix(pt, "/*") || pt == "*" {
+			return pt, 2
+		} else if strings.Contains(pt, "/:") {
+			return pt, 1
+		}
+		return pt, 0
+	case *regexp.Regexp:
+		return "re:" + pt.String(), 3
+	}
+	return "", -1
+}
+
+/* }}} */
+
+/* {{{ func newRouteTable(routes map[string]*Route) *routeTable
+ * 按pattern分组, 顺序固定(优先级, pattern, 路由key)
+ */
+func newRouteTable(routes map[string]*Route) *routeTable {
+	keys := make([]string, 0, len(routes))
+	for k := range routes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tb := &routeTable{exact: make(map[string]*patternRoutes)}
+	groups := make(map[string]*patternRoutes)
+	for _, k := range keys {
+		rt := routes[k]
+		pk, rank := patternRank(rt.Pattern)
+		if rank < 0 {
+			continue
+		}
+		pr, ok := groups[pk]
+		if !ok {
+			pr = &patternRoutes{pattern: rt.Pattern, key: pk, rank: rank, methods: make(map[string][]*Route)}
+			groups[pk] = pr
+			if rank == 0 {
+				tb.exact[pk] = pr
+			} else {
+				tb.params = append(tb.params, pr)
+			}
+		}
+		m := strings.ToUpper(rt.Method)
+		pr.methods[m] = append(pr.methods[m], rt)
+	}
+	sort.SliceStable(tb.params, func(i, j int) bool {
+		if tb.params[i].rank != tb.params[j].rank {
+			return tb.params[i].rank < tb.params[j].rank
+		}
+		return tb.params[i].key < tb.params[j].key
+	})
+	return tb
+}
+
+/* }}} */
+
+/* {{{ func (mux *Mux) routeTable() *routeTable
+ * Run时生成, 之后不再变化
+ */
+func (mux *Mux) routeTable() *routeTable {
+	mux.rtOnce.Do(func() {
+		mux.rtable = newRouteTable(mux.Routes)
+	})
+	return mux.rtable
+}
+
+/* }}} */
+
+/* {{{ func (pr *patternRoutes) collect(rts map[string]*Route, listener string)
+ * 把listener上可见的路由加入rts, 已有的方法不覆盖
+ */
+func (pr *patternRoutes) collect(rts map[string]*Route, listener string) {
+	for m, list := range pr.methods {
+		if _, ok := rts[m]; ok {
+			continue
+		}
+		for _, rt := range list {
+			if rt.exposed(listener) {
+				rts[m] = rt
+				break
+			}
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ func (mux *Mux) matchRoutes(urlPath, listener string) map[string]*Route
+ * listener上路径匹配的所有路由, key为方法, 同一方法取优先级最高的pattern(不含参数的优先)
+ */
+func (mux *Mux) matchRoutes(urlPath, listener string) map[string]*Route {
+	tb := mux.routeTable()
+	rts := make(map[string]*Route)
+	if pr, ok := tb.exact[urlPath]; ok {
+		pr.collect(rts, listener)
+	}
+	for _, pr := range tb.params {
+		if matchPattern(pr.pattern, urlPath) {
+			pr.collect(rts, listener)
+		}
+	}
+	return rts
+}
+
+/* }}} */
+
+/* {{{ func allowMethods(rts map[string]*Route) string
+ *
+ */
+func allowMethods(rts map[string]*Route) string {
+	ms := []string{"OPTIONS"}
+	for m := range rts {
+		if m != "OPTIONS" {
+			ms = append(ms, m)
+		}
+	}
+	sort.Strings(ms[1:])
+	return strings.Join(ms, ", ")
+}
+
+/* }}} */
+
+/* {{{ func CrossOrigin(c *web.C, h http.Handler) http.Handler
+ * 应答OPTIONS(包括预检), 并给跨域请求加上响应头
+ */
+func CrossOrigin(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		listener, _ := c.Env[ListenerKey].(string)
+		rts := DMux.matchRoutes(r.URL.Path, listener)
+		o := r.Header.Get(originHeader)
+		if r.Method != "OPTIONS" {
+			if cs := routeCORS(rts[r.Method]); cs != nil {
+				w.Header().Add("Vary", "Origin") //不允许的来源也要加, 避免缓存混用
+				if ao := cs.allowOrigin(o); ao != "" {
+					setCORSHeaders(w.Header(), cs, ao)
+					if len(cs.Expose) > 0 {
+						w.Header().Set("Access-Control-Expose-Headers", strings.Join(cs.Expose, ", "))
+					}
+				}
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		rm := r.Header.Get(acRequestMethod)
+		if _, ok := rts["OPTIONS"]; (ok && rm == "") || len(rts) == 0 { //自定义了OPTIONS或者没有路由
+			h.ServeHTTP(w, r)
+			return
+		}
+		rc := rcHolder(*c, w, r)
+		w.Header().Set("Allow", allowMethods(rts))
+		if o != "" && rm != "" { //预检
+			rt, ok := rts[strings.ToUpper(rm)]
+			cs := routeCORS(rt)
+			if cs != nil {
+				w.Header().Add("Vary", "Origin")
+			}
+			ao := ""
+			if ok && cs != nil {
+				ao = cs.allowOrigin(o)
+			}
+			if ao == "" {
+				rc.SetStatus(http.StatusForbidden)
+				rc.WriteBytes(nil)
+				return
+			}
+			setCORSHeaders(w.Header(), cs, ao)
+			w.Header().Set("Access-Control-Allow-Methods", allowMethods(rts))
+			if len(cs.Headers) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(cs.Headers, ", "))
+			} else if rh := r.Header.Get(acRequestHeader); rh != "" {
+				w.Header().Set("Access-Control-Allow-Headers", rh)
+			}
+			if cs.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cs.MaxAge))
+			}
+		}
+		rc.SetStatus(http.StatusNoContent)
+		rc.WriteBytes(nil)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+/* }}} */
+
+/* {{{ func setCORSHeaders(hd http.Header, cs *CORS, ao string)
+ * ao为allowOrigin的结果, 明确配置的来源返回请求的Origin, 这样带credentials也有效; *不带credentials
+ */
+func setCORSHeaders(hd http.Header, cs *CORS, ao string) {
+	hd.Set("Access-Control-Allow-Origin", ao)
+	if cs.Credentials && ao != "*" {
+		hd.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+/* }}} */
@@ -0,0 +1,196 @@
+/* 游标分页(keyset), 以排序字段+主键的最后一行值定位下一页
+ * 可以为NULL的排序字段, NULL总是排在最后(ORDER BY f IS NULL, f)
+ */
+package ogo
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Odinman/ogo/utils"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// 游标, 对客户端不透明
+type Cursor struct {
+	Orders []string      `json:"o"` // 排序字段, "-"前缀为降序
+	Values []interface{} `json:"v"` // 对应的值, 字符串或null
+}
+
+/* {{{ func ParseCursor(s string) (*Cursor, error)
+ * 解析客户端传入的游标, 空字符串代表第一页
+ */
+func ParseCursor(s string) (*Cursor, error) {
+	cur := new(Cursor)
+	if s == "" {
+		return cur, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, cur); err != nil || len(cur.Orders) == 0 || len(cur.Orders) != len(cur.Values) {
+		return nil, ErrInvalidCursor
+	}
+	return cur, nil
+}
+
+/* }}} */
+
+/* {{{ func (cur *Cursor) String() string
+ * 编码为url安全的字符串
+ */
+func (cur *Cursor) String() string {
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+/* }}} */
+
+/* {{{ func cursorOrders(obs []*OrderBy) []string
+ * 排序字段, 降序加"-"前缀
+ */
+func cursorOrders(obs []*OrderBy) []string {
+	os := make([]string, len(obs))
+	for i, ob := range obs {
+		if ob.Sort == "DESC" {
+			os[i] = "-" + ob.Field
+		} else {
+			os[i] = ob.Field
+		}
+	}
+	return os
+}
+
+/* }}} */
+
+/* {{{ func nullableColumn(col utils.StructColumn) bool
+ * 指针字段可以为NULL, pk除外
+ */
+func nullableColumn(col utils.StructColumn) bool {
+	return col.Type != nil && col.Type.Kind() == reflect.Ptr && !col.TagOptions.Contains(DBTAG_PK)
+}
+
+/* }}} */
+
+/* {{{ func newCursor(row reflect.Value, obs []*OrderBy) (*Cursor, error)
+ * 根据最后一行生成下一页游标, NULL值为nil
+ */
+func newCursor(row reflect.Value, obs []*OrderBy) (*Cursor, error) {
+	cols := make(map[string]utils.StructColumn)
+	for _, col := range utils.ReadStructColumns(row.Interface(), true) {
+		cols[col.Tag] = col
+	}
+	cur := &Cursor{Orders: cursorOrders(obs), Values: make([]interface{}, len(obs))}
+	for i, ob := range obs {
+		col, ok := cols[ob.Field]
+		if !ok {
+			return nil, fmt.Errorf("not found order field: %s", ob.Field)
+		}
+		fv := utils.FieldByIndex(row, col.Index)
+		for fv.IsValid() && fv.Kind() == reflect.Ptr && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		if !fv.IsValid() || fv.Kind() == reflect.Ptr { // NULL
+			cur.Values[i] = nil
+			continue
+		}
+		switch v := fv.Interface().(type) {
+		case time.Time:
+			cur.Values[i] = v.Format(time.RFC3339Nano)
+		case encoding.TextMarshaler:
+			t, err := v.MarshalText()
+			if err != nil {
+				return nil, err
+			}
+			cur.Values[i] = string(t)
+		default:
+			cur.Values[i] = fmt.Sprint(v)
+		}
+	}
+	return cur, nil
+}
+
+/* }}} */
+
+/* {{{ func (cur *Cursor) where(m Model, obs []*OrderBy, d Dialect) (string, []interface{}, error)
+ * 生成keyset条件: (a > ?) OR (a = ? AND b > ?) ..., 降序时为 <
+ * NULL排在最后: 非NULL值之后还有NULL, NULL之后只能靠后面的字段区分
+ */
+func (cur *Cursor) where(m Model, obs []*OrderBy, d Dialect) (string, []interface{}, error) {
+	if len(cur.Orders) == 0 { //第一页
+		return "", nil, nil
+	}
+	if strings.Join(cur.Orders, ",") != strings.Join(cursorOrders(obs), ",") { //排序变了, 游标失效
+		return "", nil, ErrInvalidCursor
+	}
+	cols := make(map[string]utils.StructColumn)
+	for _, col := range utils.ReadStructColumns(m, true) {
+		cols[col.Tag] = col
+	}
+	vs := make([]interface{}, len(obs))
+	nullable := make([]bool, len(obs))
+	for i, ob := range obs {
+		col, found := cols[ob.Field]
+		if !found {
+			return "", nil, ErrInvalidCursor
+		}
+		nullable[i] = nullableColumn(col)
+		if cur.Values[i] == nil && nullable[i] {
+			continue
+		}
+		sv, ok := cur.Values[i].(string)
+		if !ok {
+			return "", nil, ErrInvalidCursor
+		}
+		cv, err := convertValue(col.Type, sv)
+		if err != nil {
+			return "", nil, ErrInvalidCursor
+		}
+		vs[i] = cv
+	}
+	ors := make([]string, 0, len(obs))
+	args := make([]interface{}, 0)
+	for i, ob := range obs {
+		if vs[i] == nil { //NULL之后没有更大的值
+			continue
+		}
+		ands := make([]string, 0, i+1)
+		as := make([]interface{}, 0, i+1)
+		for j := 0; j < i; j++ {
+			if vs[j] == nil {
+				ands = append(ands, "T."+d.Quote(obs[j].Field)+" IS NULL")
+			} else {
+				ands = append(ands, "T."+d.Quote(obs[j].Field)+" = ?")
+				as = append(as, vs[j])
+			}
+		}
+		op := ">"
+		if ob.Sort == "DESC" {
+			op = "<"
+		}
+		if nullable[i] {
+			ands = append(ands, fmt.Sprintf("(T.%s %s ? OR T.%s IS NULL)", d.Quote(ob.Field), op, d.Quote(ob.Field)))
+		} else {
+			ands = append(ands, fmt.Sprintf("T.%s %s ?", d.Quote(ob.Field), op))
+		}
+		as = append(as, vs[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		args = append(args, as...)
+	}
+	if len(ors) == 0 { //全部为NULL(不会发生, pk不为NULL)
+		return "", nil, ErrInvalidCursor
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args, nil
+}
+
+/* }}} */
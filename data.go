@@ -35,15 +35,22 @@ type SelfConverter interface {
 type BaseConverter struct{}
 
 /* {{{ func OpenDB(tag,dns string) error
- *
+ * 根据dns选择数据库方言, 见ParseDSN
  */
 func OpenDB(tag, dns string) (err error) {
-	Debug("open mysql: %s,%s", tag, dns)
+	d, dsn := ParseDSN(dns)
+	Debug("open %s: %s,%s", d.Name(), tag, dsn)
+	if err = checkDriver(d); err != nil {
+		Debug("open error: %s", err)
+		return
+	}
 	gorp.TraceOn(fmt.Sprintf("[%s]", tag), Logger())
 	gorp.SetTypeConvert(BaseConverter{})
-	if err = gorp.Open(tag, "mysql", dns); err != nil {
+	if err = gorp.Open(tag, d.DriverName(), dsn); err != nil {
 		Debug("open error: %s", err)
+		return
 	}
+	dialects.Set(tag, d)
 	return
 }
 
@@ -92,25 +99,14 @@ func (_ BaseConverter) FromDb(target interface{}) (gorp.CustomScanner, bool) {
 	switch t := target.(type) {
 	case **time.Time:
 		binder := func(holder, target interface{}) error {
-			var err error
 			if holder.(*sql.NullString).Valid {
-				var dt time.Time
-				str := holder.(*sql.NullString).String
-				switch len(str) {
-				case 10, 19, 21, 22, 23, 24, 25, 26: // up to "YYYY-MM-DD HH:MM:SS.MMMMMM"
-					if str == base[:len(str)] {
-						return nil
-					}
-					dt, err = time.ParseInLocation(timeFormat[:len(str)], str, time.Local)
-				default:
-					err = fmt.Errorf("Invalid Time-String: %s", str)
-					return err
-				}
+				// mysql/postgres/sqlite的时间格式都支持
+				dt, err := parseDBTime(holder.(*sql.NullString).String)
 				if err != nil {
 					return err
+				} else if dt.IsZero() {
+					return nil
 				}
-				//dt = dt.UTC()
-				dt = dt.Local()
 				*(target.(**time.Time)) = &dt
 				return nil
 			}
@@ -119,25 +115,14 @@ func (_ BaseConverter) FromDb(target interface{}) (gorp.CustomScanner, bool) {
 		return gorp.CustomScanner{new(sql.NullString), target, binder}, true
 	case *time.Time:
 		binder := func(holder, target interface{}) error {
-			var err error
 			if holder.(*sql.NullString).Valid {
-				var dt time.Time
-				str := holder.(*sql.NullString).String
-				switch len(str) {
-				case 10, 19, 21, 22, 23, 24, 25, 26: // up to "YYYY-MM-DD HH:MM:SS.MMMMMM"
-					if str == base[:len(str)] {
-						return nil
-					}
-					dt, err = time.ParseInLocation(timeFormat[:len(str)], str, time.Local)
-				default:
-					err = fmt.Errorf("Invalid Time-String: %s", str)
-					return err
-				}
+				// mysql/postgres/sqlite的时间格式都支持
+				dt, err := parseDBTime(holder.(*sql.NullString).String)
 				if err != nil {
 					return err
+				} else if dt.IsZero() {
+					return nil
 				}
-				//dt = dt.UTC()
-				dt = dt.Local()
 				*(target.(*time.Time)) = dt
 				return nil
 			}
@@ -143,6 +143,15 @@ func PostHook(hook OgoHook) {
 
 /* }}} */
 
+/* {{{ func Use(prefix string, mws ...Middleware)
+ * 路径前缀中间件
+ */
+func Use(prefix string, mws ...Middleware) {
+	DMux.Use(prefix, mws...)
+}
+
+/* }}} */
+
 /* {{{ func AddTagHook(tag string, hook TagHook)
  * tag hook
  */
@@ -151,3 +160,12 @@ func AddTagHook(tag string, hook TagHook) {
 }
 
 /* }}} */
+
+/* {{{ func AddContextHook(tag string, hook ContextHook, onEmpty bool)
+ * context tag hook
+ */
+func AddContextHook(tag string, hook ContextHook, onEmpty bool) {
+	DMux.AddContextHook(tag, hook, onEmpty)
+}
+
+/* }}} */
@@ -0,0 +1,8 @@
+// +build sqlite
+
+package ogo
+
+// sqlite驱动需要cgo, 只在 -tags sqlite 时编译
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
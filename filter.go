@@ -0,0 +1,477 @@
+/* filter表达式, 支持AND/OR/NOT/括号/IN/BETWEEN/IS NULL */
+package ogo
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/Odinman/ogo/utils"
+)
+
+const (
+	//逻辑节点
+	FOP_AND = "AND"
+	FOP_OR  = "OR"
+	FOP_NOT = "NOT"
+	//叶子节点
+	FOP_EQ       = "="
+	FOP_NE       = "!="
+	FOP_GT       = ">"
+	FOP_GTE      = ">="
+	FOP_LT       = "<"
+	FOP_LTE      = "<="
+	FOP_CONTAINS = "~" // 包含, 同参数前缀"~"
+	FOP_LIKE     = "LIKE"
+	FOP_NLIKE    = "NOT LIKE"
+	FOP_IN       = "IN"
+	FOP_NIN      = "NOT IN"
+	FOP_BETWEEN  = "BETWEEN"
+	FOP_NBETWEEN = "NOT BETWEEN"
+	FOP_NULL     = "IS NULL"
+	FOP_NNULL    = "IS NOT NULL"
+
+	_FILTER_MAX_DEPTH = 32  //最大嵌套层数
+	_FILTER_MAX_LEAFS = 100 //最多比较条件数
+)
+
+// filter表达式树
+type FilterNode struct {
+	Op       string
+	Children []*FilterNode // AND/OR/NOT
+	Field    string        // 叶子节点字段
+	Values   []interface{} // 叶子节点值
+}
+
+/* {{{ func ParseFilter(s string) (*FilterNode, error)
+ * 解析filter表达式, 如: (status=1 OR status=2) AND created>2024-01-01
+ */
+func ParseFilter(s string) (*FilterNode, error) {
+	toks, err := lexFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("empty filter")
+	}
+	p := &filterParser{toks: toks}
+	n, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t != nil {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	if len(n.Leafs()) > _FILTER_MAX_LEAFS {
+		return nil, fmt.Errorf("too many conditions")
+	}
+	return n, nil
+}
+
+/* }}} */
+
+/* {{{ func (n *FilterNode) Leafs() []*FilterNode
+ * 所有叶子节点
+ */
+func (n *FilterNode) Leafs() (ls []*FilterNode) {
+	if n == nil {
+		return nil
+	}
+	if n.Field != "" {
+		return []*FilterNode{n}
+	}
+	for _, c := range n.Children {
+		ls = append(ls, c.Leafs()...)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (n *FilterNode) convert(cols map[string]utils.StructColumn) FieldErrors
+ * 根据字段类型转换叶子节点的值
+ */
+func (n *FilterNode) convert(cols map[string]utils.StructColumn) FieldErrors {
+	fe := make(FieldErrors)
+	for _, l := range n.Leafs() {
+		col, ok := cols[l.Field]
+		if !ok {
+			fe[l.Field] = ErrNonSearchable.Error()
+			continue
+		}
+		if l.Op == FOP_CONTAINS || l.Op == FOP_LIKE || l.Op == FOP_NLIKE { //模糊查询保持字符串
+			continue
+		}
+		for i, v := range l.Values {
+			if sv, ok := v.(string); ok {
+				cv, err := convertValue(col.Type, sv)
+				if err != nil {
+					fe[l.Field] = err.Error()
+					break
+				}
+				l.Values[i] = cv
+			}
+		}
+	}
+	if len(fe) > 0 {
+		return fe
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (n *FilterNode) sql(d Dialect) (string, []interface{})
+ * 生成where子句, 所有值以参数绑定
+ */
+func (n *FilterNode) sql(d Dialect) (string, []interface{}) {
+	switch n.Op {
+	case FOP_AND, FOP_OR:
+		exprs := make([]string, 0, len(n.Children))
+		args := make([]interface{}, 0)
+		for _, c := range n.Children {
+			e, a := c.sql(d)
+			exprs = append(exprs, e)
+			args = append(args, a...)
+		}
+		return "(" + strings.Join(exprs, " "+n.Op+" ") + ")", args
+	case FOP_NOT:
+		e, a := n.Children[0].sql(d)
+		return "NOT (" + e + ")", a
+	}
+	col := "T." + d.Quote(n.Field)
+	switch n.Op {
+	case FOP_CONTAINS:
+		return col + " LIKE ?" + likeEscape, []interface{}{"%" + escapeLike(fmt.Sprint(n.Values[0])) + "%"}
+	case FOP_LIKE, FOP_NLIKE: // 模式中用!转义通配符
+		return fmt.Sprintf("%s %s ?%s", col, n.Op, likeEscape), n.Values
+	case FOP_IN, FOP_NIN:
+		return fmt.Sprintf("%s %s (%s)", col, n.Op, placeholders(len(n.Values))), n.Values
+	case FOP_BETWEEN, FOP_NBETWEEN:
+		return fmt.Sprintf("(%s %s ? AND ?)", col, n.Op), n.Values
+	case FOP_NULL, FOP_NNULL:
+		return col + " " + n.Op, nil
+	default: // = != > >= < <=
+		return fmt.Sprintf("%s %s ?", col, n.Op), n.Values
+	}
+}
+
+/* }}} */
+
+// 词法单元
+type filterToken struct {
+	text   string
+	quoted bool // 引号括起来的值, 不作为关键字
+	pos    int
+}
+
+/* {{{ func lexFilter(s string) ([]*filterToken, error)
+ * 分词, 值中包含空格或特殊字符时用单/双引号
+ */
+func lexFilter(s string) ([]*filterToken, error) {
+	toks := make([]*filterToken, 0)
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',' || r == '~' || r == '=':
+			toks = append(toks, &filterToken{text: string(r), pos: i})
+			i++
+		case r == '!' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(rs) && (rs[i+1] == '=' || (r == '<' && rs[i+1] == '>')) {
+				op += string(rs[i+1])
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected '!' at %d", i)
+			}
+			toks = append(toks, &filterToken{text: op, pos: i})
+			i += len(op)
+			if op == "<>" {
+				toks[len(toks)-1].text = FOP_NE
+			}
+		case r == '\'' || r == '"':
+			var b []rune
+			j := i + 1
+			for ; j < len(rs); j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				} else if rs[j] == r {
+					break
+				}
+				b = append(b, rs[j])
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			toks = append(toks, &filterToken{text: string(b), quoted: true, pos: i})
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(rs) && !unicode.IsSpace(rs[j]) && !strings.ContainsRune("()!=<>~,'\"", rs[j]); j++ {
+			}
+			toks = append(toks, &filterToken{text: string(rs[i:j]), pos: i})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+/* }}} */
+
+type filterParser struct {
+	toks []*filterToken
+	i    int
+}
+
+func (p *filterParser) peek() *filterToken {
+	if p.i < len(p.toks) {
+		return p.toks[p.i]
+	}
+	return nil
+}
+
+func (p *filterParser) next() *filterToken {
+	t := p.peek()
+	if t != nil {
+		p.i++
+	}
+	return t
+}
+
+// 是否关键字(不区分大小写)
+func (p *filterParser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t != nil && !t.quoted && strings.EqualFold(t.text, kw)
+}
+
+func (p *filterParser) expect(text string) error {
+	t := p.next()
+	if t == nil {
+		return fmt.Errorf("expect %q but end of filter", text)
+	}
+	if t.quoted || !strings.EqualFold(t.text, text) {
+		return fmt.Errorf("expect %q but got %q at %d", text, t.text, t.pos)
+	}
+	return nil
+}
+
+/* {{{ func (p *filterParser) parseOr(depth int) (*FilterNode, error)
+ * or := and (OR and)*
+ */
+func (p *filterParser) parseOr(depth int) (*FilterNode, error) {
+	n, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	if !p.isKeyword(FOP_OR) {
+		return n, nil
+	}
+	on := &FilterNode{Op: FOP_OR, Children: []*FilterNode{n}}
+	for p.isKeyword(FOP_OR) {
+		p.next()
+		c, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		on.Children = append(on.Children, c)
+	}
+	return on, nil
+}
+
+/* }}} */
+
+/* {{{ func (p *filterParser) parseAnd(depth int) (*FilterNode, error)
+ * and := unary (AND unary)*
+ */
+func (p *filterParser) parseAnd(depth int) (*FilterNode, error) {
+	n, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	if !p.isKeyword(FOP_AND) {
+		return n, nil
+	}
+	an := &FilterNode{Op: FOP_AND, Children: []*FilterNode{n}}
+	for p.isKeyword(FOP_AND) {
+		p.next()
+		c, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		an.Children = append(an.Children, c)
+	}
+	return an, nil
+}
+
+/* }}} */
+
+/* {{{ func (p *filterParser) parseUnary(depth int) (*FilterNode, error)
+ * unary := NOT unary | '(' or ')' | predicate
+ */
+func (p *filterParser) parseUnary(depth int) (*FilterNode, error) {
+	if depth > _FILTER_MAX_DEPTH {
+		return nil, fmt.Errorf("filter nested too deep")
+	}
+	if p.isKeyword(FOP_NOT) {
+		p.next()
+		c, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &FilterNode{Op: FOP_NOT, Children: []*FilterNode{c}}, nil
+	}
+	if p.isKeyword("(") {
+		p.next()
+		n, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	return p.parsePredicate()
+}
+
+/* }}} */
+
+/* {{{ func (p *filterParser) parsePredicate() (*FilterNode, error)
+ * predicate := field op value | field [NOT] IN (v,...) | field [NOT] BETWEEN v AND v | field IS [NOT] NULL | field [NOT] LIKE v
+ */
+func (p *filterParser) parsePredicate() (*FilterNode, error) {
+	t := p.next()
+	if t == nil {
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+	if t.quoted || !isFilterField(t.text) {
+		return nil, fmt.Errorf("invalid field %q at %d", t.text, t.pos)
+	}
+	n := &FilterNode{Field: t.text}
+	op := p.next()
+	if op == nil {
+		return nil, fmt.Errorf("expect operator after %q", t.text)
+	}
+	not := false
+	if !op.quoted && strings.EqualFold(op.text, FOP_NOT) {
+		not = true
+		if op = p.next(); op == nil {
+			return nil, fmt.Errorf("expect operator after NOT")
+		}
+	}
+	kw := strings.ToUpper(op.text)
+	if op.quoted {
+		kw = ""
+	}
+	switch kw {
+	case FOP_EQ, FOP_NE, FOP_GT, FOP_GTE, FOP_LT, FOP_LTE, FOP_CONTAINS:
+		if not {
+			return nil, fmt.Errorf("unexpected NOT before %q", op.text)
+		}
+		n.Op = kw
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		n.Values = []interface{}{v}
+	case FOP_LIKE:
+		n.Op = FOP_LIKE
+		if not {
+			n.Op = FOP_NLIKE
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		n.Values = []interface{}{v}
+	case FOP_IN:
+		n.Op = FOP_IN
+		if not {
+			n.Op = FOP_NIN
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		for {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			n.Values = append(n.Values, v)
+			if p.isKeyword(",") {
+				p.next()
+				continue
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	case FOP_BETWEEN:
+		n.Op = FOP_BETWEEN
+		if not {
+			n.Op = FOP_NBETWEEN
+		}
+		s, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(FOP_AND); err != nil {
+			return nil, err
+		}
+		e, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		n.Values = []interface{}{s, e}
+	case "IS":
+		if not {
+			return nil, fmt.Errorf("unexpected NOT before IS")
+		}
+		n.Op = FOP_NULL
+		if p.isKeyword(FOP_NOT) {
+			p.next()
+			n.Op = FOP_NNULL
+		}
+		if err := p.expect("NULL"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid operator %q at %d", op.text, op.pos)
+	}
+	return n, nil
+}
+
+/* }}} */
+
+// 值, 引号括起来的或者普通单词
+func (p *filterParser) value() (string, error) {
+	t := p.next()
+	if t == nil {
+		return "", fmt.Errorf("expect value but end of filter")
+	}
+	if !t.quoted && (len(t.text) == 1 && strings.ContainsAny(t.text, "(),=<>~") || len(t.text) == 2 && strings.ContainsAny(t.text[:1], "!<>")) {
+		return "", fmt.Errorf("expect value but got %q at %d", t.text, t.pos)
+	}
+	return t.text, nil
+}
+
+// 字段名只允许字母数字下划线
+func isFilterField(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	switch strings.ToUpper(s) {
+	case FOP_AND, FOP_OR, FOP_NOT, FOP_IN, FOP_LIKE, FOP_BETWEEN, "IS", "NULL":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package ogo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilterSQL(t *testing.T) {
+	cases := []struct {
+		filter string
+		sql    string
+		args   []interface{}
+	}{
+		{"a=1", "T.`a` = ?", []interface{}{"1"}},
+		{"a<>1", "T.`a` != ?", []interface{}{"1"}},
+		{"a>=1 AND b<2", "(T.`a` >= ? AND T.`b` < ?)", []interface{}{"1", "2"}},
+		// AND优先于OR
+		{"a=1 OR b=2 AND c=3", "(T.`a` = ? OR (T.`b` = ? AND T.`c` = ?))", []interface{}{"1", "2", "3"}},
+		{"(a=1 OR b=2) AND c=3", "((T.`a` = ? OR T.`b` = ?) AND T.`c` = ?)", []interface{}{"1", "2", "3"}},
+		{"a=1 or b=2", "(T.`a` = ? OR T.`b` = ?)", []interface{}{"1", "2"}},
+		// NOT只作用于紧跟的条件
+		{"NOT a=1 AND b=2", "(NOT (T.`a` = ?) AND T.`b` = ?)", []interface{}{"1", "2"}},
+		{"NOT (a=1 OR b=2)", "NOT ((T.`a` = ? OR T.`b` = ?))", []interface{}{"1", "2"}},
+		{"NOT NOT a=1", "NOT (NOT (T.`a` = ?))", []interface{}{"1"}},
+		{"a IN (1, 2,3)", "T.`a` IN (?,?,?)", []interface{}{"1", "2", "3"}},
+		{"a NOT IN ('x y', \"z\")", "T.`a` NOT IN (?,?)", []interface{}{"x y", "z"}},
+		{"a BETWEEN 1 AND 5", "(T.`a` BETWEEN ? AND ?)", []interface{}{"1", "5"}},
+		{"a NOT BETWEEN 1 AND 5 AND b=2", "((T.`a` NOT BETWEEN ? AND ?) AND T.`b` = ?)", []interface{}{"1", "5", "2"}},
+		{"a IS NULL", "T.`a` IS NULL", nil},
+		{"a is not null OR b=1", "(T.`a` IS NOT NULL OR T.`b` = ?)", []interface{}{"1"}},
+		{"a LIKE 'x%'", "T.`a` LIKE ? ESCAPE '!'", []interface{}{"x%"}},
+		{"a NOT LIKE x", "T.`a` NOT LIKE ? ESCAPE '!'", []interface{}{"x"}},
+		{"a~'50%_!'", "T.`a` LIKE ? ESCAPE '!'", []interface{}{`%50!%!_!!%`}},
+		{"a='and' AND b=\"it's\"", "(T.`a` = ? AND T.`b` = ?)", []interface{}{"and", "it's"}},
+		{`a='x\'y'`, "T.`a` = ?", []interface{}{"x'y"}},
+	}
+	for _, c := range cases {
+		n, err := ParseFilter(c.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %s", c.filter, err)
+			continue
+		}
+		sql, args := n.sql(MySQL)
+		if sql != c.sql {
+			t.Errorf("ParseFilter(%q).sql = %q, want %q", c.filter, sql, c.sql)
+		}
+		if len(args) != len(c.args) || len(args) > 0 && !reflect.DeepEqual(args, c.args) {
+			t.Errorf("ParseFilter(%q) args = %#v, want %#v", c.filter, args, c.args)
+		}
+	}
+}
+
+func TestFilterLikeEscape(t *testing.T) {
+	n, err := ParseFilter("a~x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for d, want := range map[Dialect]string{
+		MySQL:    `T.` + "`a`" + ` LIKE ? ESCAPE '!'`,
+		Postgres: `T."a" LIKE ? ESCAPE '!'`,
+		SQLite:   `T."a" LIKE ? ESCAPE '!'`,
+	} {
+		if sql, _ := n.sql(d); sql != want {
+			t.Errorf("%s: %s, want %s", d.Name(), sql, want)
+		}
+	}
+}
+
+func TestParseFilterError(t *testing.T) {
+	for _, f := range []string{
+		"",
+		"a",
+		"a=",
+		"a=1 AND",
+		"(a=1",
+		"a=1)",
+		"a IN 1",
+		"a IN (1,",
+		"a BETWEEN 1",
+		"a BETWEEN 1 OR 2",
+		"a IS 1",
+		"a NOT = 1",
+		"a ! 1",
+		"a='x",
+		"'a'=1",
+		"AND=1",
+		"1a=1",
+		"a=1 b=2",
+	} {
+		if _, err := ParseFilter(f); err == nil {
+			t.Errorf("ParseFilter(%q) should fail", f)
+		}
+	}
+}
+
+func TestConditionFilterArgs(t *testing.T) {
+	cases := []struct {
+		filter string
+		args   []interface{}
+	}{
+		{"a IN (1,2) OR b=3", []interface{}{"1", "2", "3"}},
+		{"a IS NULL", nil},
+		{"a IS NULL AND b BETWEEN 1 AND 2", []interface{}{"1", "2"}},
+	}
+	for _, c := range cases {
+		n, err := ParseFilter(c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var args []interface{}
+		con := &Condition{Field: _PARAM_FILTER, Filter: n}
+		con.where(func(query string, as ...interface{}) {
+			args = append(args, as...)
+		}, MySQL)
+		if len(args) != len(c.args) || len(args) > 0 && !reflect.DeepEqual(args, c.args) {
+			t.Errorf("filter %q binds %#v, want %#v", c.filter, args, c.args)
+		}
+	}
+}
+
+// 空集合的IN不匹配任何记录, NOT IN不排除任何记录
+func TestConditionEmptyIn(t *testing.T) {
+	cases := []struct {
+		con  *Condition
+		want []string
+	}{
+		{&Condition{Field: "a", Is: []string{}}, []string{"1=0"}},
+		{&Condition{Field: "a", Not: []interface{}{}}, []string{"1=1"}},
+		{&Condition{Field: "a", Is: []string{}, Not: []string{"x"}}, []string{"1=0", "T.`a` NOT IN (?)"}},
+	}
+	for _, c := range cases {
+		var ws []string
+		c.con.where(func(query string, as ...interface{}) {
+			ws = append(ws, query)
+		}, MySQL)
+		if !reflect.DeepEqual(ws, c.want) {
+			t.Errorf("%+v: %q, want %q", c.con, ws, c.want)
+		}
+	}
+}
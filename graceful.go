@@ -0,0 +1,312 @@
+// +build !daemon
+
+/* 平滑关闭以及重启(http模式)
+ * SIGTERM/SIGINT: 不再接受新连接, 等待处理中的请求结束(最多ShutdownTimeout秒)后退出
+ * SIGUSR2: 启动新进程并把监听socket传给它, 新进程开始服务(通过管道通知)后旧进程才平滑退出, 之后新进程接管pidfile
+ * SIGHUP: 重新加载TLS证书
+ */
+package ogo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/VividCortex/godaemon"
+	"github.com/nightlyone/lockfile"
+)
+
+const (
+	listenFDKey       = "__OGO_LISTEN_FDS" // 继承的监听socket, 格式: name:fd,name:fd
+	readyFDKey        = "__OGO_READY_FD"   // 新进程开始服务后写这个管道通知旧进程
+	daemonEnvPrefix   = "__DAEMON_"        // godaemon的环境变量, 重启时去掉, 新进程重新daemonize
+	_INHERIT_FD       = 3                  // 0,1,2之后
+	_LOCK_RETRY_DELAY = 100 * time.Millisecond
+	_READY_TIMEOUT    = 30 * time.Second // 等待新进程开始服务的最长时间
+)
+
+// 继承的监听socket
+type inheritedFile struct {
+	name string
+	fd   int
+	f    *os.File
+}
+
+// 正在服务的listener
+type activeListener struct {
+	spec *listenerSpec
+	l    net.Listener // 重启时传给新进程
+	srv  *http.Server
+}
+
+var (
+	// 一直持有引用(godaemon会为同一个fd生成新的*os.File, 避免这些被回收时关闭fd)
+	inheritedFiles []*inheritedFile
+	readyFile      *os.File // 通知旧进程的管道, 通知后关闭
+
+	ErrNotReady     = errors.New("new process exited before serving")
+	ErrReadyTimeout = errors.New("new process not serving in time")
+)
+
+/* {{{ func inheritListeners() []*inheritedFile
+ * 父进程通过SIGUSR2重启时传过来的监听socket, 按fd排序
+ */
+func inheritListeners() []*inheritedFile {
+	if inheritedFiles != nil {
+		return inheritedFiles
+	}
+	inheritedFiles = make([]*inheritedFile, 0)
+	for _, item := range splitList(os.Getenv(listenFDKey)) {
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if fd, err := strconv.Atoi(kv[1]); err == nil && fd > 2 {
+			inheritedFiles = append(inheritedFiles, &inheritedFile{name: kv[0], fd: fd, f: os.NewFile(uintptr(fd), kv[0])})
+		}
+	}
+	sort.Slice(inheritedFiles, func(i, j int) bool { return inheritedFiles[i].fd < inheritedFiles[j].fd })
+	if fd, err := strconv.Atoi(os.Getenv(readyFDKey)); err == nil && fd > 2 && len(inheritedFiles) > 0 {
+		readyFile = os.NewFile(uintptr(fd), "ready")
+	}
+	return inheritedFiles
+}
+
+/* }}} */
+
+/* {{{ func daemonFiles(ifs []*inheritedFile) []**os.File
+ * daemonize过程中保持打开, godaemon按顺序放在fd 3之后, 与环境变量中的一致(通知管道在监听socket之后)
+ */
+func daemonFiles(ifs []*inheritedFile) []**os.File {
+	files := make([]**os.File, 0, len(ifs)+1)
+	for _, inf := range ifs {
+		files = append(files, &inf.f)
+	}
+	if readyFile != nil {
+		files = append(files, &readyFile)
+	}
+	return files
+}
+
+/* }}} */
+
+/* {{{ func notifyReady()
+ * 通知旧进程已经开始服务, 旧进程已经放弃等待(管道关闭)则退出, 避免两个进程同时服务
+ */
+func notifyReady() {
+	if readyFile == nil {
+		return
+	}
+	_, err := readyFile.Write([]byte{1})
+	readyFile.Close()
+	readyFile = nil
+	if err != nil {
+		Critical("notify ready failed, exit: %s", err)
+		os.Exit(1)
+	}
+}
+
+/* }}} */
+
+/* {{{ func reexec(als []*activeListener) error
+ * 启动新进程(同样的参数), 监听socket从fd 3开始依次传过去, 之后是通知管道
+ * 新进程开始服务后才返回nil
+ */
+func reexec(als []*activeListener) error {
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	fds := make([]string, 0, len(als))
+	for _, al := range als {
+		fl, ok := al.l.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			return fmt.Errorf("listener %s can't be inherited: %T", al.spec.name, al.l)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fds = append(fds, fmt.Sprint(al.spec.name, ":", _INHERIT_FD+len(fds)))
+		files = append(files, f)
+	}
+	path, err := godaemon.GetExecutablePath()
+	if err != nil {
+		return err
+	}
+	environ := make([]string, 0)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, listenFDKey+"=") && !strings.HasPrefix(kv, readyFDKey+"=") && !strings.HasPrefix(kv, daemonEnvPrefix) {
+			environ = append(environ, kv)
+		}
+	}
+	environ = append(environ, listenFDKey+"="+strings.Join(fds, ","))
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	environ = append(environ, fmt.Sprint(readyFDKey, "=", _INHERIT_FD+len(fds)))
+	files = append(files, w)
+	dir, _ := os.Getwd()
+	proc, err := os.StartProcess(path, os.Args, &os.ProcAttr{Dir: dir, Env: environ, Files: files})
+	w.Close() //只留新进程持有写端, 它退出则读到EOF
+	if err != nil {
+		return err
+	}
+	Info("new process started: %d", proc.Pid)
+	proc.Release()
+
+	ready := make(chan error, 1)
+	go func() {
+		if _, err := r.Read(make([]byte, 1)); err != nil {
+			ready <- ErrNotReady
+			return
+		}
+		ready <- nil
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(_READY_TIMEOUT): //关闭读端后新进程通知失败会自己退出
+		err = ErrReadyTimeout
+	}
+	return err
+}
+
+/* }}} */
+
+/* {{{ func waitLock(l lockfile.Lockfile)
+ * 重启时旧进程还持有pidfile, 等它退出后再锁, 一直重试(超过关闭时间还没锁到的只警告一次)
+ */
+func waitLock(l lockfile.Lockfile) {
+	deadline := time.Now().Add(env.ShutdownTimeout + 5*time.Second)
+	warned := false
+	for {
+		err := l.TryLock()
+		if err == nil {
+			Info("pidfile locked: %s", env.PidFile)
+			return
+		} else if !warned && time.Now().After(deadline) {
+			Warn("lock pidfile failed, keep retrying: %s", err)
+			warned = true
+		}
+		time.Sleep(_LOCK_RETRY_DELAY)
+	}
+}
+
+/* }}} */
+
+/* {{{ func (mux *Mux) serve(ifs []*inheritedFile, lock lockfile.Lockfile)
+ * 服务直到收到退出信号
+ */
+func (mux *Mux) serve(ifs []*inheritedFile, lock lockfile.Lockfile) {
+	specs, err := listenerSpecs()
+	if err != nil {
+		panic(err)
+	}
+	inherited := make(map[string]*os.File)
+	for _, inf := range ifs {
+		inherited[inf.name] = inf.f
+	}
+	als := make([]*activeListener, 0, len(specs))
+	for _, sp := range specs {
+		l, err := sp.listen(inherited[sp.name])
+		if err != nil {
+			panic(err)
+		}
+		delete(inherited, sp.name)
+		m := ListenerMux(sp.name)
+		m.Compile()
+		al := &activeListener{spec: sp, l: l, srv: &http.Server{Handler: m}}
+		go func(al *activeListener) {
+			var err error
+			if al.spec.certs != nil { //证书由GetCertificate提供
+				al.srv.TLSConfig = al.spec.tlsConfig()
+				err = al.srv.ServeTLS(al.l, "", "")
+			} else {
+				err = al.srv.Serve(al.l)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				Critical("[%s] serve error: %s", al.spec.name, err)
+			}
+		}(al)
+		Warn("[%s] listening on %s %s", sp.name, sp.network, sp.addr)
+		als = append(als, al)
+	}
+	for name, f := range inherited { //配置中已经去掉的
+		Info("[%s] inherited listener not configured, closed", name)
+		f.Close()
+	}
+	if len(ifs) > 0 { //继承来的, 通知旧进程退出, 等它释放pidfile
+		notifyReady()
+		go waitLock(lock)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR2, syscall.SIGHUP)
+	for sig := range sigs {
+		if sig == syscall.SIGHUP {
+			for _, al := range als {
+				if al.spec.certs == nil {
+					continue
+				}
+				if err := al.spec.certs.load(); err != nil {
+					Warn("[%s] reload certificate failed: %s", al.spec.name, err)
+				} else {
+					Info("[%s] certificate reloaded", al.spec.name)
+				}
+			}
+			continue
+		}
+		if sig == syscall.SIGUSR2 {
+			if err := reexec(als); err != nil {
+				Warn("restart failed: %s", err)
+				continue
+			}
+			for _, al := range als { //socket文件由新进程继续使用
+				if ul, ok := al.l.(*net.UnixListener); ok {
+					ul.SetUnlinkOnClose(false)
+				}
+			}
+		}
+		Warn("received %s, shutting down", sig)
+		break
+	}
+	signal.Stop(sigs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), env.ShutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, al := range als {
+		wg.Add(1)
+		go func(al *activeListener) {
+			defer wg.Done()
+			if err := al.srv.Shutdown(ctx); err != nil {
+				Warn("[%s] shutdown: %s", al.spec.name, err)
+			}
+		}(al)
+	}
+	wg.Wait()
+	if err := lock.Unlock(); err != nil {
+		Info("unlock pidfile: %s", err)
+	}
+	Warn("Ogo(http mode) stopped")
+	if accessor != nil {
+		accessor.Close()
+	}
+	if logger != nil {
+		logger.Close()
+	}
+}
+
+/* }}} */
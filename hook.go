@@ -4,15 +4,119 @@ package ogo
 import (
 	"reflect"
 	"sync"
+	"sync/atomic"
+
+	"github.com/Odinman/ogo/utils"
+)
+
+const (
+	// tag hook的操作类型
+	HOOK_CREATE = iota + 1
+	HOOK_UPDATE
+	HOOK_REPLACE // PUT, 全量替换
 )
 
 type OgoHook func(c *RESTContext) error
 
+// 钩子列表, 添加时复制(copy-on-write), 执行时无锁
 type HStack struct {
-	lock      sync.Mutex
-	preHooks  []OgoHook
-	postHooks []OgoHook
+	lock      sync.Mutex   // 用于添加
+	preHooks  atomic.Value // []OgoHook
+	postHooks atomic.Value // []OgoHook
+	locked    bool         // 原来的方式: 整个请求持有lock执行, 只用于基准测试对比
 }
 
 // struct里面的field可定义处理函数
 type TagHook func(v reflect.Value) reflect.Value
+
+// 带上下文的tag hook参数
+type HookContext struct {
+	Ctx    *RESTContext
+	Model  Model
+	Column utils.StructColumn
+	Value  reflect.Value // 字段当前值(可能为空)
+	Op     int           // HOOK_CREATE/HOOK_UPDATE/HOOK_REPLACE
+}
+
+// 带上下文的tag hook, 返回字段的新值(无效的reflect.Value表示不修改), 出错则拒绝
+type ContextHook func(hc *HookContext) (reflect.Value, error)
+
+type contextHook struct {
+	hook    ContextHook
+	onEmpty bool // 字段为空时也执行
+}
+
+/* {{{ func (hs *HStack) PreHooks() []OgoHook
+ * 当前的pre hooks, 返回的slice不可修改
+ */
+func (hs *HStack) PreHooks() []OgoHook {
+	hooks, _ := hs.preHooks.Load().([]OgoHook)
+	return hooks
+}
+
+/* }}} */
+
+/* {{{ func (hs *HStack) PostHooks() []OgoHook
+ * 当前的post hooks, 返回的slice不可修改
+ */
+func (hs *HStack) PostHooks() []OgoHook {
+	hooks, _ := hs.postHooks.Load().([]OgoHook)
+	return hooks
+}
+
+/* }}} */
+
+/* {{{ func appendHook(v *atomic.Value, hook OgoHook)
+ * 复制后追加, 正在执行的请求仍然使用旧的列表
+ */
+func appendHook(v *atomic.Value, hook OgoHook) {
+	old, _ := v.Load().([]OgoHook)
+	hooks := make([]OgoHook, len(old), len(old)+1)
+	copy(hooks, old)
+	v.Store(append(hooks, hook))
+}
+
+/* }}} */
+
+/* {{{ func (hc *HookContext) Older() Model
+ * 旧记录, 更新/替换时才有
+ */
+func (hc *HookContext) Older() Model {
+	if (hc.Op != HOOK_UPDATE && hc.Op != HOOK_REPLACE) || hc.Model == nil {
+		return nil
+	}
+	return hc.Model.GetOlder()
+}
+
+/* }}} */
+
+/* {{{ func runTagHook(hk interface{}, hc *HookContext) error
+ * 执行tag hook, 兼容旧的TagHook(只在有值时执行)
+ */
+func runTagHook(hk interface{}, hc *HookContext) error {
+	fv := hc.Value
+	empty := !fv.IsValid() || utils.IsEmptyValue(fv)
+	switch h := hk.(type) {
+	case TagHook:
+		if !empty {
+			fv.Set(h(reflect.ValueOf(hc.Model)))
+		}
+	case contextHook:
+		if empty && !h.onEmpty {
+			return nil
+		}
+		nv, err := h.hook(hc)
+		if err != nil {
+			return err
+		}
+		if nv.IsValid() && fv.IsValid() {
+			if nv.Type() != fv.Type() && nv.Type().ConvertibleTo(fv.Type()) {
+				nv = nv.Convert(fv.Type())
+			}
+			fv.Set(nv)
+		}
+	}
+	return nil
+}
+
+/* }}} */
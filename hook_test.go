@@ -0,0 +1,81 @@
+package ogo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Odinman/ogo/libs/logs"
+	"github.com/zenazn/goji/web"
+)
+
+// init()需要配置文件(在程序目录的conf下), 包级变量先于init()初始化, 这时还没有t.TempDir()
+// 把程序路径指到临时目录, 测试结束后删除
+var testAppDir = writeTestConfig()
+
+var benchOnce sync.Once
+
+func writeTestConfig() string {
+	dir, err := ioutil.TempDir("", "ogo-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Args[0] = filepath.Join(dir, filepath.Base(os.Args[0]))
+	os.MkdirAll(filepath.Join(dir, "conf"), 0755)
+	conf := fmt.Sprintf("DebugLevel = %d\nAccessPath = %s\n", logs.LevelError, filepath.Join(dir, "access.log"))
+	ioutil.WriteFile(filepath.Join(dir, "conf", "app.conf"), []byte(conf), 0644)
+	return dir
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	os.RemoveAll(testAppDir)
+	os.Exit(code)
+}
+
+// 模拟需要等待的钩子(比如查询登录状态)
+func benchHook(c *RESTContext) error {
+	time.Sleep(100 * time.Microsecond)
+	return nil
+}
+
+// 只加pre hook: 原来的方式在pre hook时加锁, 直到请求结束才释放
+func benchHandler() web.HandlerFunc {
+	benchOnce.Do(func() {
+		DMux.PreHook(benchHook)
+	})
+	rt := NewRoute("/bench", "bench", "GET", func(c *RESTContext) {
+		c.SetStatus(http.StatusNoContent)
+	})
+	return handlerWrap(rt)
+}
+
+func benchServe(b *testing.B, locked bool) {
+	h := benchHandler()
+	DMux.Hooks.locked = locked
+	defer func() { DMux.Hooks.locked = false }()
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r := httptest.NewRequest("GET", "/bench", nil)
+			h(web.C{Env: make(map[interface{}]interface{})}, httptest.NewRecorder(), r)
+		}
+	})
+}
+
+// handlerWrap, 钩子列表无锁读取
+func BenchmarkHandlerWrapHooks(b *testing.B) {
+	benchServe(b, false)
+}
+
+// 对照: handlerWrap按原来的方式整个请求持有钩子锁
+func BenchmarkHandlerWrapHooksLocked(b *testing.B) {
+	benchServe(b, true)
+}
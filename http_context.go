@@ -56,15 +56,21 @@ func (rc *RESTContext) Output(data interface{}) (err error) {
 	// 以下仍然返回json
 	rc.SetHeader("Content-Type", "application/json; charset=UTF-8")
 	var content []byte
-	if method := strings.ToLower(rc.Request.Method); method != "head" {
-		if data != nil {
-			if env.IndentJSON {
-				content, _ = json.MarshalIndent(data, "", "  ")
-			} else {
-				content, _ = json.Marshal(data)
-			}
+	if data != nil {
+		if env.IndentJSON {
+			content, _ = json.MarshalIndent(data, "", "  ")
+		} else {
+			content, _ = json.Marshal(data)
 		}
 	}
+	// 条件GET, 客户端缓存有效则不输出内容
+	if rc.conditional() && rc.notModified(content, data) {
+		rc.Status = http.StatusNotModified
+		content = nil
+	}
+	if method := strings.ToLower(rc.Request.Method); method == "head" {
+		content = nil
+	}
 	//write header & data
 	_, err = rc.WriteBytes(content)
 
@@ -172,6 +178,13 @@ func (rc *RESTContext) HTTPError(status int) (err error) {
  * 输出内容,如果需要压缩,统一在这里进行
  */
 func (rc *RESTContext) WriteBytes(data []byte) (n int, e error) {
+	// 输出之前结束事务, 提交失败要告诉客户端
+	if err := rc.finishTx(); err != nil {
+		rc.Error("commit failed: %s", err)
+		rc.Status = http.StatusInternalServerError
+		rc.SetHeader("Content-Type", "application/json; charset=UTF-8")
+		data, _ = json.Marshal(rc.NewRESTError(http.StatusInternalServerError, "commit failed"))
+	}
 	if dLen := len(data); dLen > 0 { //有内容才需要
 		if env.EnableGzip == true && rc.Request.Header.Get("Accept-Encoding") != "" {
 			splitted := strings.SplitN(rc.Request.Header.Get("Accept-Encoding"), ",", -1)
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"regexp"
 	"runtime/debug"
+	"strconv"
 	"strings"
 
 	"github.com/Odinman/ogo/utils"
@@ -23,6 +24,10 @@ const (
 	_PARAM_START   = "start"
 	_PARAM_END     = "end"
 	_PARAM_ORDERBY = "orderby"
+	_PARAM_FILTER  = "filter"
+	_PARAM_CURSOR  = "cursor"
+	_PARAM_TOTAL   = "total"
+	_PARAM_EXPAND  = "expand"
 
 	//特殊前缀
 	_PPREFIX_NOT  = '!'
@@ -44,6 +49,7 @@ const (
 	CTYPE_ORDER
 	CTYPE_PAGE
 	CTYPE_RAW
+	CTYPE_FILTER
 )
 
 var (
@@ -54,9 +60,24 @@ var (
 	otpHeader          = http.CanonicalHeaderKey("X-Qh-Otp")
 	contentDisposition = http.CanonicalHeaderKey("Content-Disposition")
 	contentMD5         = http.CanonicalHeaderKey("Content-MD5")
-	rcHolder           func(c web.C, w http.ResponseWriter, r *http.Request) *RESTContext
+	ifMatch            = http.CanonicalHeaderKey("If-Match")
+	ifNoneMatch        = http.CanonicalHeaderKey("If-None-Match")
+	ifModifiedSince    = http.CanonicalHeaderKey("If-Modified-Since")
 )
 
+/* {{{ func rcHolder(c web.C, w http.ResponseWriter, r *http.Request) *RESTContext
+ * 当前请求的RESTContext, 由EnvInit放在c.Env中(每个请求各自一份)
+ */
+func rcHolder(c web.C, w http.ResponseWriter, r *http.Request) *RESTContext {
+	if fn, ok := c.Env[rcHolderKey].(func(c web.C, w http.ResponseWriter, r *http.Request) *RESTContext); ok {
+		return fn(c, w, r)
+	}
+	rc, _ := newContext(c, w, r)
+	return rc
+}
+
+/* }}} */
+
 /* {{{ func getCTypeByPrefix(p string) int
  *
  */
@@ -130,9 +151,10 @@ func EnvInit(c *web.C, h http.Handler) http.Handler {
 		ac.Http.IP = r.RemoteAddr
 
 		//init RESTContext
-		var rcErr error
-		var rc *RESTContext
-		rc, rcHolder, rcErr = RCHolder(*c, w, r)
+		rc, holder, rcErr := RCHolder(*c, w, r)
+		if holder != nil {
+			c.Env[rcHolderKey] = holder
+		}
 		rc.Access = ac
 		rc.Access.Http.ReqLength = len(rc.RequestBody)
 		if rcErr != nil {
@@ -164,8 +186,17 @@ func Defer(c *web.C, h http.Handler) http.Handler {
 				//debug.PrintStack()
 				rc.Critical("%s", debug.Stack())
 				//http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				if rc.tx != nil && rc.tx.Started() { // panic回滚事务
+					if e := rc.tx.Rollback(); e != nil {
+						rc.Warn("rollback failed: %s", e)
+					}
+				}
 				rc.HTTPError(http.StatusInternalServerError)
 			}
+			// 没有经过WriteBytes输出的, 在这里结束事务
+			if err := rc.finishTx(); err != nil {
+				rc.Error("commit failed: %s", err)
+			}
 			// release locks
 			rc.ReleaseLocks()
 			// launch tasks
@@ -207,6 +238,10 @@ func ParseHeaders(c *web.C, h http.Handler) http.Handler {
 		if cs := r.Header.Get(contentMD5); cs != "" {
 			rc.SetEnv(ContentMD5Key, cs)
 		}
+		// 乐观锁
+		if im := r.Header.Get(ifMatch); im != "" {
+			rc.SetEnv(IfMatchKey, im)
+		}
 
 		// Accept
 		//rc.Debug("html selector: %s", c.Env[SelectorKey])
@@ -265,6 +300,8 @@ func ParseParams(c *web.C, h http.Handler) http.Handler {
 		// 根据ogo规则解析参数
 		var ct int
 		var p, pp string
+		var cur *Cursor
+		var total bool
 		rc.setTimeRangeFromStartEnd()
 		for k, v := range r.Form {
 			switch k { //处理参数
@@ -272,6 +309,12 @@ func ParseParams(c *web.C, h http.Handler) http.Handler {
 				rc.setTimeRangeFromDate(v)
 			case _PARAM_ORDERBY:
 				rc.setOrderBy(v)
+			case _PARAM_FILTER:
+				//表达式查询, 多个filter之间为AND
+				if err := rc.setFilter(v); err != nil {
+					rc.RESTBadRequest(FieldErrors{_PARAM_FILTER: err.Error()})
+					return
+				}
 			case _PARAM_FIELDS:
 				//过滤字段
 				if len(v) > 1 { //传了多个
@@ -291,6 +334,24 @@ func ParseParams(c *web.C, h http.Handler) http.Handler {
 				if len(v) > 0 {
 					p = v[0]
 				}
+			case _PARAM_CURSOR: //游标分页, 第一页传空值
+				var err error
+				if cur, err = ParseCursor(v[0]); err != nil {
+					rc.RESTBadRequest(FieldErrors{_PARAM_CURSOR: err.Error()})
+					return
+				}
+			case _PARAM_TOTAL: //游标分页时是否需要总数
+				total, _ = strconv.ParseBool(v[0])
+			case _PARAM_EXPAND: //展开关联资源, 逗号分隔
+				es := make([]string, 0)
+				for _, ev := range v {
+					for _, e := range strings.Split(ev, ",") {
+						if e = strings.TrimSpace(e); e != "" {
+							es = append(es, e)
+						}
+					}
+				}
+				rc.SetEnv(ExpandKey, es)
 			default:
 				//除了以上的特别字段,其他都是条件查询
 				var cv interface{}
@@ -342,7 +403,12 @@ func ParseParams(c *web.C, h http.Handler) http.Handler {
 			}
 		}
 		//记录分页信息
-		rc.SetEnv(PaginationKey, NewPagination(p, pp))
+		pg := NewPagination(p, pp)
+		if cur != nil {
+			pg.Cursor = cur
+			pg.WithTotal = total
+		}
+		rc.SetEnv(PaginationKey, pg)
 
 		h.ServeHTTP(w, r)
 	}
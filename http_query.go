@@ -2,10 +2,14 @@
 package ogo
 
 import (
+	"encoding"
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/Odinman/ogo/utils"
 )
 
 const (
@@ -19,6 +23,20 @@ const (
 	_TIME_FORM  = "20060102150405"
 )
 
+var (
+	// 查询条件中时间的格式, 依次尝试
+	timeLayouts = []string{
+		_TIME_FORM,
+		_DATE_FORM,
+		_DATE_FORM1,
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02T15:04:05.999999999",
+		time.RFC3339Nano,
+	}
+	timeType      = reflect.TypeOf(time.Time{})
+	unmarshalType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
 //时间段
 type TimeRange struct {
 	Start time.Time
@@ -27,9 +45,11 @@ type TimeRange struct {
 
 // 分页信息
 type Pagination struct {
-	Page    int
-	PerPage int
-	Offset  int
+	Page      int
+	PerPage   int
+	Offset    int
+	Cursor    *Cursor // 游标分页, 不为nil时忽略Page/Offset
+	WithTotal bool    // 游标分页时是否计算总数
 }
 
 // 条件信息
@@ -199,27 +219,68 @@ func (rc *RESTContext) setTimeRangeFromStartEnd() {
 
 /* }}} */
 
-/* {{{ func (rc *RESTContext) setOrderBy(p string) {
- * 时间段信息
+/* {{{ func (rc *RESTContext) setOrderBy(p []string) {
+ * 排序信息, 如: orderby=-created,name,+id, "-"降序, "+"升序, 无前缀按字段默认
+ * 兼容旧格式: orderby=created,asc
  */
 func (rc *RESTContext) setOrderBy(p []string) {
-	ob := new(OrderBy)
-	rc.SetEnv(OrderByKey, ob)
-	if len(p) > 0 { //只有一个, 可通过 "{start},{end}"方式传
-		pieces := strings.SplitN(p[0], ",", 2)
-		ob.Field = pieces[0]
-		ob.Sort = "DESC" //默认降序
-		if len(pieces) > 1 && strings.ToUpper(pieces[1]) == "ASC" {
-			ob.Sort = "ASC"
+	obs := make([]*OrderBy, 0)
+	for _, v := range p {
+		for _, piece := range strings.Split(v, ",") {
+			//"+"在url中会被解码为空格
+			asc := strings.HasPrefix(piece, " ") || strings.HasPrefix(piece, "+")
+			piece = strings.TrimSpace(piece)
+			if piece == "" {
+				continue
+			}
+			if s := strings.ToUpper(piece); (s == "ASC" || s == "DESC") && len(obs) > 0 { //旧格式, 修饰前一个字段
+				obs[len(obs)-1].Sort = s
+				continue
+			}
+			ob := &OrderBy{Field: strings.TrimLeft(piece, "+-")}
+			if asc {
+				ob.Sort = "ASC"
+			} else if piece[0] == '-' {
+				ob.Sort = "DESC"
+			}
+			obs = append(obs, ob)
 		}
-		Debug("[orderby][field: %s][sort: %s]", ob.Field, ob.Sort)
 	}
+	Debug("[orderby]%v", obs)
+	rc.SetEnv(OrderByKey, obs)
 
 	return
 }
 
 /* }}} */
 
+/* {{{ func (rc *RESTContext) setFilter(p []string) error
+ * filter表达式
+ */
+func (rc *RESTContext) setFilter(p []string) error {
+	fn := &FilterNode{Op: FOP_AND}
+	for _, fs := range p {
+		if strings.TrimSpace(fs) == "" {
+			continue
+		}
+		n, err := ParseFilter(fs)
+		if err != nil {
+			return err
+		}
+		fn.Children = append(fn.Children, n)
+	}
+	switch len(fn.Children) {
+	case 0:
+		return nil
+	case 1:
+		fn = fn.Children[0]
+	}
+	rc.setCondition(NewCondition(CTYPE_FILTER, _PARAM_FILTER, fn))
+	return nil
+}
+
+/* }}} */
+
 /* {{{ func (rc *RESTContext) GetQueryParam(key string) (string, int)
  */
 func (rc *RESTContext) GetQueryParam(key string) (r string, c int) {
@@ -243,43 +304,95 @@ func (rc *RESTContext) GetQueryParams(key string) (rs []string) {
 
 /* }}} */
 
-/* {{{ func ParseCondition(typ string, con *Condition) *Condition
- *
+/* {{{ func ConvertCondition(m Model, col utils.StructColumn, con *Condition) error
+ * 根据字段类型转换条件值, 出错时返回字段错误(key为json名)
  */
-func ParseCondition(typ string, con *Condition) *Condition {
-	switch typ {
-	case "*time.Time":
-		if con.Is != nil {
-			if cv, ok := con.Is.(string); ok {
-				if t, err := time.ParseInLocation(_TIME_FORM, cv, Env().Location); err == nil {
-					con.Is = t
-				}
-			}
+func ConvertCondition(m Model, col utils.StructColumn, con *Condition) error {
+	var err error
+	convert := func(v interface{}) interface{} {
+		if v == nil || err != nil {
+			return v
 		}
-		if con.Not != nil {
-			if cv, ok := con.Not.(string); ok {
-				if t, err := time.ParseInLocation(_TIME_FORM, cv, Env().Location); err == nil {
-					con.Not = t
+		var cv interface{}
+		switch vt := v.(type) {
+		case string:
+			cv, err = convertValue(col.Type, vt)
+		case []string:
+			vs := make([]interface{}, len(vt))
+			for i, sv := range vt {
+				if vs[i], err = convertValue(col.Type, sv); err != nil {
+					break
 				}
 			}
+			cv = vs
+		default: //已经转换过
+			return v
 		}
-		if con.Gt != nil {
-			if cv, ok := con.Gt.(string); ok {
-				if t, err := time.ParseInLocation(_TIME_FORM, cv, Env().Location); err == nil {
-					con.Gt = t
-				}
-			}
+		if err != nil {
+			return v
 		}
-		if con.Lt != nil {
-			if cv, ok := con.Lt.(string); ok {
-				if t, err := time.ParseInLocation(_TIME_FORM, cv, Env().Location); err == nil {
-					con.Lt = t
-				}
+		return cv
+	}
+	con.Is = convert(con.Is)
+	con.Not = convert(con.Not)
+	con.Gt = convert(con.Gt)
+	con.Lt = convert(con.Lt)
+	if oc, ok := con.Or.(*Condition); ok {
+		oc.Is = convert(oc.Is)
+	}
+	if err != nil {
+		return FieldErrors{jsonName(reflect.TypeOf(m), col): err.Error()}
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func convertValue(t reflect.Type, s string) (interface{}, error)
+ * 字符串转换为字段类型的值, 支持整数/浮点/布尔/时间以及实现了TextUnmarshaler的枚举
+ */
+func convertValue(t reflect.Type, s string) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		for _, layout := range timeLayouts {
+			if tv, err := time.ParseInLocation(layout, s, Env().Location); err == nil {
+				return tv, nil
 			}
 		}
-		return con
-	default:
-		return con
+		return nil, fmt.Errorf("invalid time: %s", s)
+	}
+	if reflect.PtrTo(t).Implements(unmarshalType) { //枚举等自定义类型
+		ev := reflect.New(t)
+		if err := ev.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return nil, fmt.Errorf("invalid value: %s", s)
+		}
+		return ev.Elem().Interface(), nil
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if iv, err := strconv.ParseInt(s, 10, t.Bits()); err == nil {
+			return iv, nil
+		}
+		return nil, fmt.Errorf("invalid integer: %s", s)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if uv, err := strconv.ParseUint(s, 10, t.Bits()); err == nil {
+			return uv, nil
+		}
+		return nil, fmt.Errorf("invalid unsigned integer: %s", s)
+	case reflect.Float32, reflect.Float64:
+		if fv, err := strconv.ParseFloat(s, t.Bits()); err == nil {
+			return fv, nil
+		}
+		return nil, fmt.Errorf("invalid number: %s", s)
+	case reflect.Bool:
+		if bv, err := strconv.ParseBool(s); err == nil {
+			return bv, nil
+		}
+		return nil, fmt.Errorf("invalid boolean: %s", s)
+	default: //字符串以及其他类型不转换
+		return s, nil
 	}
 }
 
@@ -0,0 +1,61 @@
+/* model生命周期回调, model实现了对应接口即可, http请求和后台worker都会调用 */
+package ogo
+
+import (
+	"reflect"
+)
+
+// 创建前, 出错则不创建
+type BeforeCreateInterface interface {
+	BeforeCreate() error
+}
+
+// 创建后, 出错则CreateRow返回错误(有事务时会回滚)
+type AfterCreateInterface interface {
+	AfterCreate() error
+}
+
+// 更新前, 出错则不更新
+type BeforeUpdateInterface interface {
+	BeforeUpdate() error
+}
+
+// 更新后
+type AfterUpdateInterface interface {
+	AfterUpdate() error
+}
+
+// 删除前, 出错则不删除
+type BeforeDeleteInterface interface {
+	BeforeDelete() error
+}
+
+// 删除后
+type AfterDeleteInterface interface {
+	AfterDelete() error
+}
+
+// 读取后, GetRow/GetRows的每一条记录都会调用
+type AfterFindInterface interface {
+	AfterFind() error
+}
+
+/* {{{ func afterFindList(ms interface{}) error
+ * 列表中每条记录调用AfterFind
+ */
+func afterFindList(ms interface{}) error {
+	rows := reflect.Indirect(reflect.ValueOf(ms))
+	if rows.Kind() != reflect.Slice {
+		return nil
+	}
+	for i := 0; i < rows.Len(); i++ {
+		if h, ok := rows.Index(i).Interface().(AfterFindInterface); ok {
+			if err := h.AfterFind(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/* }}} */
@@ -0,0 +1,299 @@
+/* 多个listener, 每个listener有自己的web.Mux
+ * router通过Listeners指定暴露在哪些listener上, 为空则为全部公开的listener(不包括admin)
+ * Port = 8001               ; off则不监听http
+ * [tls]
+ * port = 8443
+ * cert = conf/server.crt    ; SIGHUP重新加载
+ * key = conf/server.key
+ * min_version = 1.2         ; 1.0/1.1/1.2/1.3
+ * [unix]
+ * path = /var/run/app.sock  ; 放在nginx后面
+ * mode = 0660
+ * [admin]
+ * port = 9001               ; 只有端口则监听127.0.0.1
+ */
+package ogo
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zenazn/goji"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	LISTENER_HTTP  = "http"  // Port
+	LISTENER_HTTPS = "https" // [tls]
+	LISTENER_UNIX  = "unix"  // [unix]
+	LISTENER_ADMIN = "admin" // [admin], 内部接口
+
+	ListenerKey = "_listener_" //请求来自哪个listener
+
+	DEFAULT_SOCKET_MODE = 0660
+)
+
+var (
+	ErrNoCertificate = errors.New("tls cert and key required")
+	ErrTLSVersion    = errors.New("unknown tls min_version")
+
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	publicListeners = []string{LISTENER_HTTP, LISTENER_HTTPS, LISTENER_UNIX}
+
+	listenerLock  sync.Mutex
+	listenerMuxes = make(map[string]*web.Mux)
+)
+
+/* {{{ func ListenerMux(name string) *web.Mux
+ * listener对应的mux, http为goji.DefaultMux, 其余的第一次使用时创建
+ */
+func ListenerMux(name string) *web.Mux {
+	listenerLock.Lock()
+	defer listenerLock.Unlock()
+	if m, ok := listenerMuxes[name]; ok {
+		return m
+	}
+	var m *web.Mux
+	if name == LISTENER_HTTP {
+		m = goji.DefaultMux
+	} else {
+		m = web.New()
+	}
+	useMiddlewares(m, name)
+	listenerMuxes[name] = m
+	return m
+}
+
+/* }}} */
+
+/* {{{ func useMiddlewares(m *web.Mux, name string)
+ * 每个mux都用同样的middleware
+ */
+func useMiddlewares(m *web.Mux, name string) {
+	m.Use(listenerEnv(name))
+	m.Use(EnvInit)
+	m.Use(Defer)
+	m.Use(CrossOrigin) //OPTIONS以及跨域
+	m.Use(ParseHeaders)
+	m.Use(ParseParams)
+}
+
+/* }}} */
+
+/* {{{ func listenerEnv(name string) func(c *web.C, h http.Handler) http.Handler
+ * 在env中记录listener名称
+ */
+func listenerEnv(name string) func(c *web.C, h http.Handler) http.Handler {
+	return func(c *web.C, h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if c.Env == nil {
+				c.Env = make(map[interface{}]interface{})
+			}
+			c.Env[ListenerKey] = name
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+/* }}} */
+
+/* {{{ func (rtr *Router) listeners() []string
+ *
+ */
+func (rtr *Router) listeners() []string {
+	if rtr == nil || len(rtr.Listeners) == 0 {
+		return publicListeners
+	}
+	return rtr.Listeners
+}
+
+/* }}} */
+
+/* {{{ func (rtr *Router) muxes() []*web.Mux
+ * router的路由注册到这些mux
+ */
+func (rtr *Router) muxes() []*web.Mux {
+	ms := make([]*web.Mux, 0)
+	for _, name := range rtr.listeners() {
+		ms = append(ms, ListenerMux(name))
+	}
+	return ms
+}
+
+/* }}} */
+
+/* {{{ func (rt *Route) exposed(listeners ...string) bool
+ * 路由是否暴露在其中任意一个listener上, 不指定(或者为空)表示不限
+ */
+func (rt *Route) exposed(listeners ...string) bool {
+	if len(listeners) == 0 {
+		return true
+	}
+	for _, l := range listeners {
+		if l == "" {
+			return true
+		}
+		for _, name := range rt.router.listeners() {
+			if name == l {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/* }}} */
+
+// listener配置
+type listenerSpec struct {
+	name       string
+	network    string // tcp/unix
+	addr       string
+	mode       os.FileMode // unix socket文件权限
+	minVersion uint16
+	certs      *certLoader // https
+}
+
+// 证书, 可以重新加载
+type certLoader struct {
+	cert, key string
+	current   atomic.Value // *tls.Certificate
+}
+
+/* {{{ func (cl *certLoader) load() error
+ * 加载失败保留原来的证书
+ */
+func (cl *certLoader) load() error {
+	cert, err := tls.LoadX509KeyPair(cl.cert, cl.key)
+	if err != nil {
+		return err
+	}
+	cl.current.Store(&cert)
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (cl *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+ *
+ */
+func (cl *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cl.current.Load().(*tls.Certificate), nil
+}
+
+/* }}} */
+
+/* {{{ func tcpAddr(port, host string) string
+ * 只有端口则加上host
+ */
+func tcpAddr(port, host string) string {
+	if strings.Contains(port, ":") {
+		return port
+	}
+	return host + ":" + port
+}
+
+/* }}} */
+
+/* {{{ func listenerSpecs() ([]*listenerSpec, error)
+ * 根据配置生成listener
+ */
+func listenerSpecs() ([]*listenerSpec, error) {
+	specs := make([]*listenerSpec, 0)
+	if env.Port != "off" {
+		specs = append(specs, &listenerSpec{name: LISTENER_HTTP, network: "tcp", addr: tcpAddr(env.Port, "")})
+	}
+	cfg := Config()
+	if cfg == nil {
+		return specs, nil
+	}
+	if port := cfg.String("tls::port"); port != "" {
+		sp := &listenerSpec{name: LISTENER_HTTPS, network: "tcp", addr: tcpAddr(port, ""), minVersion: tls.VersionTLS12}
+		if v := cfg.String("tls::min_version"); v != "" {
+			var ok bool
+			if sp.minVersion, ok = tlsVersions[v]; !ok {
+				return nil, ErrTLSVersion
+			}
+		}
+		cl := &certLoader{cert: cfg.String("tls::cert"), key: cfg.String("tls::key")}
+		if cl.cert == "" || cl.key == "" {
+			return nil, ErrNoCertificate
+		}
+		if err := cl.load(); err != nil {
+			return nil, err
+		}
+		sp.certs = cl
+		specs = append(specs, sp)
+	}
+	if path := cfg.String("unix::path"); path != "" {
+		sp := &listenerSpec{name: LISTENER_UNIX, network: "unix", addr: path, mode: DEFAULT_SOCKET_MODE}
+		if m := cfg.String("unix::mode"); m != "" {
+			mode, err := strconv.ParseUint(m, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("unix::mode: %s", err)
+			}
+			sp.mode = os.FileMode(mode)
+		}
+		specs = append(specs, sp)
+	}
+	if port := cfg.String("admin::port"); port != "" {
+		specs = append(specs, &listenerSpec{name: LISTENER_ADMIN, network: "tcp", addr: tcpAddr(port, "127.0.0.1")})
+	}
+	return specs, nil
+}
+
+/* }}} */
+
+/* {{{ func (sp *listenerSpec) listen(f *os.File) (net.Listener, error)
+ * 有继承的socket则使用, 否则新建
+ */
+func (sp *listenerSpec) listen(f *os.File) (net.Listener, error) {
+	if f != nil {
+		return net.FileListener(f)
+	}
+	if sp.network == "unix" {
+		// 上次没有清理的socket文件
+		if fi, err := os.Lstat(sp.addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(sp.addr)
+		}
+		l, err := net.Listen("unix", sp.addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(sp.addr, sp.mode); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+	return net.Listen(sp.network, sp.addr)
+}
+
+/* }}} */
+
+/* {{{ func (sp *listenerSpec) tlsConfig() *tls.Config
+ *
+ */
+func (sp *listenerSpec) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     sp.minVersion,
+		GetCertificate: sp.certs.getCertificate,
+	}
+}
+
+/* }}} */
@@ -0,0 +1,158 @@
+/* 路由中间件, 作用于单个路由(RouteOption)、整个router或者路径前缀
+ * 执行顺序(由外到内): 前缀 -> router -> 路由, 同一级按添加顺序
+ * 全局的PreHook/PostHook仍然在最外层
+ */
+package ogo
+
+import (
+	"strings"
+)
+
+// 中间件, 包住next; 不调用next即短路
+type Middleware func(next Handler) Handler
+
+// 前缀中间件
+type prefixMiddleware struct {
+	prefix string
+	mws    []Middleware
+}
+
+/* {{{ func Before(hook OgoHook) Middleware
+ * 在handler之前执行, 出错则输出错误, 不再往下执行
+ */
+func Before(hook OgoHook) Middleware {
+	return func(next Handler) Handler {
+		return func(c *RESTContext) {
+			if err := hook(c); err != nil {
+				c.RESTError(err)
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ func After(hook OgoHook) Middleware
+ * 在handler之后执行(此时已经输出), 错误只记录
+ */
+func After(hook OgoHook) Middleware {
+	return func(next Handler) Handler {
+		return func(c *RESTContext) {
+			next(c)
+			if err := hook(c); err != nil {
+				c.Warn("after hook error: %s", err)
+			}
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ func Chain(h Handler, mws ...Middleware) Handler
+ * 组合中间件, mws[0]在最外层
+ */
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i] != nil {
+			h = mws[i](h)
+		}
+	}
+	return h
+}
+
+/* }}} */
+
+/* {{{ func routeMiddlewares(o interface{}) []Middleware
+ * RouteOption中KEY_MIDDLEWARE的值, 可以是Middleware或者[]Middleware
+ */
+func routeMiddlewares(o interface{}) []Middleware {
+	switch mw := o.(type) {
+	case Middleware:
+		return []Middleware{mw}
+	case func(Handler) Handler:
+		return []Middleware{mw}
+	case []Middleware:
+		return mw
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func hasPathPrefix(p, prefix string) bool
+ * 按路径段匹配, /user 匹配 /user 和 /user/xx, 不匹配 /users
+ */
+func hasPathPrefix(p, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+/* }}} */
+
+/* {{{ func (mux *Mux) Use(prefix string, mws ...Middleware)
+ * 路径前缀中间件, 只匹配字符串形式的路由pattern; 需在Run之前添加
+ */
+func (mux *Mux) Use(prefix string, mws ...Middleware) {
+	mux.mwLock.Lock()
+	defer mux.mwLock.Unlock()
+	mux.prefixes = append(mux.prefixes, &prefixMiddleware{prefix: prefix, mws: mws})
+}
+
+/* }}} */
+
+/* {{{ func (mux *Mux) prefixMiddlewares(p interface{}) []Middleware
+ *
+ */
+func (mux *Mux) prefixMiddlewares(p interface{}) []Middleware {
+	ps, ok := p.(string)
+	if !ok || mux == nil {
+		return nil
+	}
+	mux.mwLock.Lock()
+	defer mux.mwLock.Unlock()
+	mws := make([]Middleware, 0)
+	for _, pm := range mux.prefixes {
+		if hasPathPrefix(ps, pm.prefix) {
+			mws = append(mws, pm.mws...)
+		}
+	}
+	return mws
+}
+
+/* }}} */
+
+/* {{{ func (rtr *Router) Use(mws ...Middleware)
+ * router中间件, 作用于这个router的所有路由; 需在Run之前添加
+ */
+func (rtr *Router) Use(mws ...Middleware) {
+	rtr.Middlewares = append(rtr.Middlewares, mws...)
+}
+
+/* }}} */
+
+/* {{{ func (rt *Route) handler() Handler
+ * 第一次请求时组合中间件, 之后不再变化
+ */
+func (rt *Route) handler() Handler {
+	rt.once.Do(func() {
+		mws := make([]Middleware, 0)
+		if rt.router != nil {
+			mws = append(mws, rt.router.Mux.prefixMiddlewares(rt.Pattern)...)
+			mws = append(mws, rt.router.Middlewares...)
+		} else {
+			mws = append(mws, DMux.prefixMiddlewares(rt.Pattern)...)
+		}
+		if rt.Options != nil {
+			mws = append(mws, routeMiddlewares(rt.Options.Get(KEY_MIDDLEWARE))...)
+		}
+		rt.chain = Chain(rt.Handler, mws...)
+	})
+	return rt.chain
+}
+
+/* }}} */
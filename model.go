@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 
@@ -54,9 +55,10 @@ type List struct {
 }
 
 type ListInfo struct {
-	Page    *int        `json:"page,omitempty"`     //当前页面
-	PerPage *int        `json:"per_page,omitempty"` //每页元素个数
-	Sum     interface{} `json:"sum,omitempty"`      //求和
+	Page       *int        `json:"page,omitempty"`        //当前页面
+	PerPage    *int        `json:"per_page,omitempty"`    //每页元素个数
+	Sum        interface{} `json:"sum,omitempty"`         //求和
+	NextCursor string      `json:"next_cursor,omitempty"` //下一页游标, 为空表示没有更多
 }
 
 //错误代码
@@ -64,32 +66,52 @@ var (
 	ErrRequired      = errors.New("field is required")
 	ErrNonEditable   = errors.New("field is non-editable")
 	ErrNonSearchable = errors.New("field is non-searchable")
+	ErrNonSortable   = errors.New("field is non-sortable")
 	ErrExists        = errors.New("field value exists")
 	ErrInvalid       = errors.New("invalid_query")
 	ErrNoRecord      = errors.New("No_record")
 	ErrNeedField     = errors.New("Need field but missing")
+	ErrNoReplace     = errors.New("model does not support replace")
 )
 
+//字段错误, key为字段名
+type FieldErrors map[string]string
+
+// implement error interface
+func (fe FieldErrors) Error() string {
+	keys := make([]string, 0, len(fe))
+	for k := range fe {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	msgs := make([]string, len(keys))
+	for i, k := range keys {
+		msgs[i] = k + ": " + fe[k]
+	}
+	return strings.Join(msgs, "; ")
+}
+
 type Condition struct {
-	Table string
-	Field string
-	Is    interface{}
-	Not   interface{}
-	Or    interface{}
-	Gt    interface{}
-	Lt    interface{}
-	Like  interface{}
-	Join  interface{}
-	Range interface{} //范围条件, btween ? and ?
-	Order interface{}
-	Page  interface{}
-	Raw   string //原始字符串
+	Table  string
+	Field  string
+	Is     interface{}
+	Not    interface{}
+	Or     interface{}
+	Gt     interface{}
+	Lt     interface{}
+	Like   interface{}
+	Join   interface{}
+	Range  interface{} //范围条件, btween ? and ?
+	Order  interface{}
+	Page   interface{}
+	Raw    string      //原始字符串
+	Filter *FilterNode //filter表达式
 }
 
 //order by
 type OrderBy struct {
 	Field string
-	Sort  string
+	Sort  string // ASC/DESC, 为空时按字段默认(O/pk降序, AO升序)
 }
 
 func NewCondition(typ int, field string, cs ...interface{}) *Condition {
@@ -126,199 +148,156 @@ func NewCondition(typ int, field string, cs ...interface{}) *Condition {
 		con.Page = v
 	case CTYPE_RAW:
 		con.Raw = v.(string)
+	case CTYPE_FILTER:
+		con.Filter, _ = v.(*FilterNode)
 	default:
 	}
 	return con
 }
 
-/* {{{ func (v *Condition) DoWhere(b *gorp.Builder)
- * 只负责生成部分sql, IS/NOT/LIKE/GT/LT
+/* {{{ func (v *Condition) DoWhere(b *Query, ds ...Dialect)
+ * 只负责生成部分sql, IS/NOT/LIKE/GT/LT, 所有值都以参数绑定; 方言默认mysql
  */
-func (v *Condition) DoWhere(b *gorp.Builder) {
+func (v *Condition) DoWhere(b *Query, ds ...Dialect) {
+	v.where(func(query string, args ...interface{}) {
+		b.Where(query, args...)
+	}, dialectOf(ds))
+}
+
+/* }}} */
+
+/* {{{ func (v *Condition) where(add func(string, ...interface{}), d Dialect)
+ * 生成where子句, 交给add处理
+ */
+func (v *Condition) where(add func(string, ...interface{}), d Dialect) {
 	if v.Raw != "" {
-		b.Where(fmt.Sprint("(", v.Raw, ")"))
+		add(fmt.Sprint("(", v.Raw, ")"))
+	}
+	if v.Filter != nil {
+		q, a := v.Filter.sql(d)
+		add(q, a...)
 	}
+	col := "T." + d.Quote(v.Field)
 	if v.Is != nil {
-		//Debug("[=][key: %s]%v", v.Field, v)
-		switch vt := v.Is.(type) {
-		case string:
-			b.Where(fmt.Sprintf("T.`%s` = ?", v.Field), vt)
-		case []string:
-			//Debug("[=][slices][key: %s]%v", v.Field, v)
-			vs := bytes.Buffer{}
-			first := true
-			vs.WriteString("(")
-			for _, vv := range vt {
-				if !first {
-					vs.WriteString(",")
-				}
-				vs.WriteString(fmt.Sprintf("'%s'", vv))
-				first = false
-			}
-			vs.WriteString(")")
-			b.Where(fmt.Sprintf("T.`%s` IN %s", v.Field, vs.String()))
-		case []interface{}:
-			vs := bytes.Buffer{}
-			first := true
-			vs.WriteString("(")
-			for _, vv := range vt {
-				if !first {
-					vs.WriteString(",")
-				}
-				vs.WriteString(fmt.Sprintf("'%s'", vv))
-				first = false
+		if vs, multi := condValues(v.Is); multi {
+			if len(vs) > 0 {
+				add(fmt.Sprintf("%s IN (%s)", col, placeholders(len(vs))), vs...)
+			} else { //空集合, 不匹配任何记录
+				add("1=0")
 			}
-			vs.WriteString(")")
-			b.Where(fmt.Sprintf("T.`%s` IN %s", v.Field, vs.String()))
-		default:
+		} else {
+			add(col+" = ?", vs...)
 		}
 	}
 	if v.Not != nil {
-		switch vt := v.Not.(type) {
-		case string:
-			b.Where(fmt.Sprintf("T.`%s` != ?", v.Field), vt)
-		case []string:
-			vs := bytes.Buffer{}
-			first := true
-			vs.WriteString("(")
-			for _, vv := range vt {
-				if !first {
-					vs.WriteString(",")
-				}
-				vs.WriteString(fmt.Sprintf("'%s'", vv))
-				first = false
+		if vs, multi := condValues(v.Not); multi {
+			if len(vs) > 0 {
+				add(fmt.Sprintf("%s NOT IN (%s)", col, placeholders(len(vs))), vs...)
+			} else { //空集合, 不排除任何记录
+				add("1=1")
 			}
-			vs.WriteString(")")
-			b.Where(fmt.Sprintf("T.`%s` NOT IN %s", v.Field, vs.String()))
-		case []interface{}:
-			vs := bytes.Buffer{}
-			first := true
-			vs.WriteString("(")
-			for _, vv := range vt {
-				if !first {
-					vs.WriteString(",")
-				}
-				vs.WriteString(fmt.Sprintf("'%s'", vv))
-				first = false
-			}
-			vs.WriteString(")")
-			b.Where(fmt.Sprintf("T.`%s` NOT IN %s", v.Field, vs.String()))
-		default:
+		} else {
+			add(col+" != ?", vs...)
 		}
 	}
 	if v.Gt != nil {
-		//Debug("[>=][key: %s]%v", v.Field, v)
 		switch vt := v.Gt.(type) {
-		case string:
-			b.Where(fmt.Sprintf("T.`%s` >= '%s'", v.Field, vt))
-		case []string:
-			vs := bytes.Buffer{}
-			first := true
-			vs.WriteString("(")
-			for _, vv := range vt {
-				if !first {
-					vs.WriteString(" OR ")
-				}
-				vs.WriteString(fmt.Sprintf("T.`%s` >= '%s'", v.Field, vv))
-				first = false
-			}
-			vs.WriteString(")")
-			b.Where(vs.String())
 		case *TimeRange:
-			b.Where(fmt.Sprintf("T.`%s` >= ?", v.Field), vt.Start)
+			add(col+" >= ?", vt.Start)
 		case TimeRange:
-			b.Where(fmt.Sprintf("T.`%s` >= ?", v.Field), vt.Start)
-		case []interface{}:
-			vs := bytes.Buffer{}
-			first := true
-			vs.WriteString("(")
-			for _, vv := range vt {
-				if !first {
-					vs.WriteString(" OR ")
-				}
-				vs.WriteString(fmt.Sprintf("T.`%s` >= '%s'", v.Field, vv))
-				first = false
-			}
-			vs.WriteString(")")
-			b.Where(vs.String())
+			add(col+" >= ?", vt.Start)
 		default:
+			vs, _ := condValues(vt)
+			orWhere(add, col+" >= ?", vs)
 		}
 	}
 	if v.Lt != nil {
-		//Debug("[<][key: %s]%v", v.Field, v)
-		switch vt := v.Lt.(type) {
-		case string:
-			b.Where(fmt.Sprintf("T.`%s` < '%s'", v.Field, vt))
-		case []string:
-			vs := bytes.Buffer{}
-			first := true
-			vs.WriteString("(")
-			for _, vv := range vt {
-				if !first {
-					vs.WriteString(" OR ")
-				}
-				vs.WriteString(fmt.Sprintf("T.`%s` < '%s'", v.Field, vv))
-				first = false
-			}
-			vs.WriteString(")")
-			b.Where(vs.String())
-		case *time.Time:
-			b.Where(fmt.Sprintf("T.`%s` < ?", v.Field), vt)
-		case time.Time:
-			b.Where(fmt.Sprintf("T.`%s` < ?", v.Field), vt)
-		case []interface{}:
-			vs := bytes.Buffer{}
-			first := true
-			vs.WriteString("(")
-			for _, vv := range vt {
-				if !first {
-					vs.WriteString(" OR ")
-				}
-				vs.WriteString(fmt.Sprintf("T.`%s` < '%s'", v.Field, vv))
-				first = false
-			}
-			vs.WriteString(")")
-			b.Where(vs.String())
-		default:
-		}
+		vs, _ := condValues(v.Lt)
+		orWhere(add, col+" < ?", vs)
 	}
 	if v.Like != nil {
-		switch vt := v.Like.(type) {
-		case string:
-			b.Where(fmt.Sprintf("T.`%s` LIKE '%%%s%%'", v.Field, vt))
-		case []string:
-			vs := bytes.Buffer{}
-			first := true
-			vs.WriteString("(")
-			for _, vv := range vt {
-				if !first {
-					vs.WriteString(" OR ")
-				}
-				vs.WriteString(fmt.Sprintf("T.`%s` LIKE '%%%s%%'", v.Field, vv))
-				first = false
-			}
-			vs.WriteString(")")
-			b.Where(vs.String())
-		case []interface{}:
-			vs := bytes.Buffer{}
-			first := true
-			vs.WriteString("(")
-			for _, vv := range vt {
-				if !first {
-					vs.WriteString(" OR ")
-				}
-				vs.WriteString(fmt.Sprintf("T.`%s` LIKE '%%%s%%'", v.Field, vv))
-				first = false
-			}
-			vs.WriteString(")")
-			b.Where(vs.String())
-		default:
+		vs, _ := condValues(v.Like)
+		for i, lv := range vs {
+			vs[i] = "%" + escapeLike(fmt.Sprint(lv)) + "%"
+		}
+		orWhere(add, col+" LIKE ?"+likeEscape, vs)
+	}
+}
+
+/* }}} */
+
+/* {{{ func condValues(v interface{}) ([]interface{}, bool)
+ * 展开条件值, slice为多值
+ */
+func condValues(v interface{}) ([]interface{}, bool) {
+	switch vt := v.(type) {
+	case []interface{}:
+		return vt, true
+	case []string:
+		vs := make([]interface{}, len(vt))
+		for i, sv := range vt {
+			vs[i] = sv
+		}
+		return vs, true
+	case []byte:
+		return []interface{}{vt}, false
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+		vs := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			vs[i] = rv.Index(i).Interface()
+		}
+		return vs, true
+	}
+	return []interface{}{v}, false
+}
+
+/* }}} */
+
+/* {{{ func orWhere(add func(string, ...interface{}), expr string, vs []interface{})
+ * 同一个表达式多值时用OR连接, 每个值一个占位符
+ */
+func orWhere(add func(string, ...interface{}), expr string, vs []interface{}) {
+	switch len(vs) {
+	case 0:
+		return
+	case 1:
+		add(expr, vs[0])
+	default:
+		exprs := make([]string, len(vs))
+		for i := range vs {
+			exprs[i] = expr
 		}
+		add("("+strings.Join(exprs, " OR ")+")", vs...)
 	}
 }
 
 /* }}} */
 
+/* {{{ func placeholders(n int) string
+ * n个占位符, 逗号分隔
+ */
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+/* }}} */
+
+// LIKE的转义符, 不用\(mysql的NO_BACKSLASH_ESCAPES下字符串中的\含义不同), 各方言通用
+const likeEscape = " ESCAPE '!'"
+
+/* {{{ func escapeLike(s string) string
+ * 转义LIKE通配符, 语句中需要加上likeEscape
+ */
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+/* }}} */
+
 /* {{{ func (con *Condition) Merge(oc *Condition)
  * 直接覆盖
  */
@@ -359,6 +338,9 @@ func (con *Condition) Merge(oc *Condition) {
 	if oc.Raw != "" {
 		con.Raw = oc.Raw
 	}
+	if oc.Filter != nil {
+		con.Filter = oc.Filter
+	}
 }
 
 /* }}} */
@@ -384,7 +366,7 @@ type Model interface {
 	DBConn(tag string) *gorp.DbMap // 数据库连接
 	TableName() string             // 返回表名称, 默认结构type名字(小写), 有特别的表名称,则自己implement 这个方法
 	PKey() (string, string, bool)  // key字段,以及是否auto incr
-	ReadPrepare() (*gorp.Builder, error)
+	ReadPrepare() (*Query, error)
 
 	// data accessor
 	GetRow(ext ...interface{}) (Model, error)    //获取单条记录
@@ -403,6 +385,28 @@ type Model interface {
 	Protect() (Model, error)                     //数据保护(获取数据时过滤字段)
 }
 
+// 以下为可选接口, 嵌入BaseModel即实现, 使用时断言(同lifecycle.go)
+
+// 事务
+type TxModel interface {
+	SetTx(tx *Tx)
+	GetTx() *Tx
+	Executor(tag string) (gorp.SqlExecutor, error) // 写操作执行器, 有事务则在事务中
+}
+
+// 全量替换(PUT)
+type ReplaceModel interface {
+	ReplaceRow(ext ...interface{}) (int64, error) //替换记录(全量更新)
+}
+
+// 批量操作
+type BulkModel interface {
+	CreateRows(rows []Model) (*BulkResult, error)                           //批量创建
+	UpsertRows(rows []Model, updates ...string) (*BulkResult, error)        //批量创建, 主键冲突则更新
+	UpdateRows(set map[string]interface{}, cs ...*Condition) (int64, error) //按条件批量更新
+	DeleteRows(cs ...*Condition) (int64, error)                             //按条件批量删除
+}
+
 type Checker func(string) (interface{}, error)
 
 //基础model,在这里可以实现Model接口, 其余的只需要嵌入这个struct,就可以继承这些方法
@@ -416,9 +420,13 @@ type BaseModel struct {
 	checker    Checker                `json:"-" db:"-"`
 	conditions []*Condition           `json:"-" db:"-"`
 	pagination *Pagination            `json:"-" db:"-"`
+	orders     []*OrderBy             `json:"-" db:"-"` //最终排序
 	fields     []string               `json:"-" db:"-"`
 	older      Model                  `json:"-" db:"-"`
+	tx         *Tx                    `json:"-" db:"-"` //事务
 	filled     bool                   `json:"-" db:"-"` //是否有内容
+	patched    map[string]bool        `json:"-" db:"-"` //补丁修改的字段(PATCH的补丁格式)
+	sent       map[string]bool        `json:"-" db:"-"` //请求中传了的字段, 没有请求内容(批量/worker)时为nil
 	//base       string       `json:"-" db:"-"` //这个的作用就是判断是否是BaseModel
 }
 
@@ -429,9 +437,7 @@ func NewModel(m Model, c ...interface{}) Model {
 	//新建一个指针
 	nmi := reflect.New(reflect.Indirect(reflect.ValueOf(m)).Type()).Interface().(Model)
 	nmi.SetModel(nmi)
-	if len(c) > 0 {
-		nmi.SetCtx(c[0].(*RESTContext))
-	}
+	setModelEnv(nmi, c...)
 	return nmi
 }
 
@@ -443,14 +449,42 @@ func NewModel(m Model, c ...interface{}) Model {
 func BuildModel(m Model, c ...interface{}) Model {
 	//新建一个指针
 	m.SetModel(m)
-	if len(c) > 0 {
-		m.SetCtx(c[0].(*RESTContext))
-	}
+	setModelEnv(m, c...)
 	return m
 }
 
 /* }}} */
 
+/* {{{ func setModelEnv(m Model, c ...interface{})
+ * 可以传入*RESTContext, *Tx
+ */
+func setModelEnv(m Model, c ...interface{}) {
+	for _, ci := range c {
+		switch ct := ci.(type) {
+		case *RESTContext:
+			m.SetCtx(ct)
+		case *Tx:
+			if tm, ok := m.(TxModel); ok {
+				tm.SetTx(ct)
+			}
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ func modelTx(m Model) *Tx
+ * model所在的事务, 没有则为nil
+ */
+func modelTx(m Model) *Tx {
+	if tm, ok := m.(TxModel); ok {
+		return tm.GetTx()
+	}
+	return nil
+}
+
+/* }}} */
+
 /* {{{ func GetCondition(cs []*Condition, k string) (con *Condition, err error)
  *
  */
@@ -470,6 +504,48 @@ func GetCondition(cs []*Condition, k string) (con *Condition, err error) {
 
 /* }}} */
 
+/* {{{ func CheckConditions(m Model, cs []*Condition, obs ...*OrderBy) FieldErrors
+ * 检查查询条件以及排序字段, 只允许pk及C标记的字段查询, pk及O/AO标记的字段排序
+ */
+func CheckConditions(m Model, cs []*Condition, obs ...*OrderBy) FieldErrors {
+	searchable := make(map[string]bool)
+	sortable := make(map[string]bool)
+	for _, col := range utils.ReadStructColumns(m, true) {
+		if col.TagOptions.Contains(DBTAG_PK) || col.ExtOptions.Contains(TAG_CONDITION) {
+			searchable[col.Tag] = true
+		}
+		if col.TagOptions.Contains(DBTAG_PK) || col.ExtOptions.Contains(TAG_ORDERBY) || col.ExtOptions.Contains(TAG_AORDERBY) {
+			sortable[col.Tag] = true
+		}
+	}
+	fe := make(FieldErrors)
+	for _, c := range cs {
+		if c == nil {
+			continue
+		}
+		if c.Filter != nil { //filter表达式检查每个字段
+			for _, l := range c.Filter.Leafs() {
+				if !searchable[l.Field] {
+					fe[l.Field] = ErrNonSearchable.Error()
+				}
+			}
+		} else if !searchable[c.Field] {
+			fe[c.Field] = ErrNonSearchable.Error()
+		}
+	}
+	for _, ob := range obs {
+		if ob != nil && ob.Field != "" && !sortable[ob.Field] {
+			fe[ob.Field] = ErrNonSortable.Error()
+		}
+	}
+	if len(fe) > 0 {
+		return fe
+	}
+	return nil
+}
+
+/* }}} */
+
 /* {{{ func (bm *BaseModel) SetModel(m Model) Model
  *
  */
@@ -507,6 +583,27 @@ func (bm *BaseModel) GetCtx() *RESTContext {
 
 /* }}} */
 
+/* {{{ func (bm *BaseModel) SetTx(tx *Tx)
+ *
+ */
+func (bm *BaseModel) SetTx(tx *Tx) {
+	bm.tx = tx
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) GetTx() *Tx
+ * 没有指定事务则用请求的事务
+ */
+func (bm *BaseModel) GetTx() *Tx {
+	if bm.tx == nil && bm.ctx != nil {
+		return bm.ctx.Tx()
+	}
+	return bm.tx
+}
+
+/* }}} */
+
 /* {{{ func (bm *BaseModel) SetConditions(cs ...*Condition) (cons []*Condition, err error)
  * 生成条件
  */
@@ -520,7 +617,28 @@ func (bm *BaseModel) SetConditions(cs ...*Condition) (cons []*Condition, err err
 	if bm.conditions == nil {
 		bm.conditions = make([]*Condition, 0)
 	}
+	fe := make(FieldErrors)
 	if cols := utils.ReadStructColumns(m, true); cols != nil {
+		// filter表达式
+		if condition, e := GetCondition(cs, _PARAM_FILTER); e == nil && condition.Filter != nil {
+			scs := make(map[string]utils.StructColumn)
+			for _, col := range cols {
+				if col.TagOptions.Contains(DBTAG_PK) || col.ExtOptions.Contains(TAG_CONDITION) {
+					scs[col.Tag] = col
+				}
+			}
+			if ce := condition.Filter.convert(scs); ce != nil {
+				for k, v := range ce {
+					fe[k] = v
+				}
+			} else {
+				bm.conditions = append(bm.conditions, condition)
+			}
+		}
+		// 排序
+		if condition, e := GetCondition(cs, TAG_ORDERBY); e == nil && condition.Order != nil {
+			bm.conditions = append(bm.conditions, condition)
+		}
 		for _, col := range cols {
 			// raw
 			if condition, e := GetCondition(cs, col.Tag); e == nil && condition.Raw != "" {
@@ -537,23 +655,23 @@ func (bm *BaseModel) SetConditions(cs ...*Condition) (cons []*Condition, err err
 					Trace("get condition failed: %s", e)
 				}
 			}
-			if col.ExtOptions.Contains(TAG_ORDERBY) {
-				if condition, e := GetCondition(cs, TAG_ORDERBY); e == nil && condition.Order != nil {
-					//Debug("[SetConditions]order")
-					condition.Field = col.Tag
-					bm.conditions = append(bm.conditions, condition)
-				} else {
-					Trace("get condition failed: %s", e)
-				}
-			}
 			if col.TagOptions.Contains(DBTAG_PK) || col.ExtOptions.Contains(TAG_CONDITION) { //primary key or conditional
 				if condition, e := GetCondition(cs, col.Tag); e == nil && (condition.Is != nil || condition.Not != nil || condition.Gt != nil || condition.Lt != nil || condition.Like != nil || condition.Join != nil || condition.Or != nil) {
 					//Debug("[SetConditions][tag: %s][type: %s]%v", col.Tag, col.Type.String(), condition)
-					bm.conditions = append(bm.conditions, ParseCondition(col.Type.String(), condition))
+					if ce := ConvertCondition(m, col, condition); ce != nil { //类型不符
+						for k, v := range ce.(FieldErrors) {
+							fe[k] = v
+						}
+						continue
+					}
+					bm.conditions = append(bm.conditions, condition)
 				}
 			}
 		}
 	}
+	if len(fe) > 0 {
+		return bm.conditions, fe
+	}
 	return bm.conditions, nil
 }
 
@@ -658,11 +776,46 @@ func (bm *BaseModel) NewList() interface{} {
  * 默认数据库连接为admin
  */
 func (bm *BaseModel) DBConn(tag string) *gorp.DbMap {
+	return gorp.Using(bm.dbTag(tag))
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) Executor(tag string) (gorp.SqlExecutor, error)
+ * 写操作执行器, 有事务则在事务中执行
+ */
+func (bm *BaseModel) Executor(tag string) (gorp.SqlExecutor, error) {
+	if tx := bm.GetTx(); tx != nil {
+		return tx.Executor(bm.dbTag(tag))
+	}
+	return bm.DBConn(tag), nil
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) reader() (gorp.SqlExecutor, Dialect)
+ * 读执行器, 写库的事务已经开启则在事务中读(能读到未提交的写入), 否则用读库
+ */
+func (bm *BaseModel) reader() (gorp.SqlExecutor, Dialect) {
+	if tx := bm.GetTx(); tx != nil {
+		if t := tx.opened(bm.dbTag(WRITETAG)); t != nil {
+			return t, GetDialect(bm.dbTag(WRITETAG))
+		}
+	}
+	return bm.DBConn(READTAG), GetDialect(bm.dbTag(READTAG))
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) dbTag(tag string) string
+ * 数据库tag, 默认为DBTAG
+ */
+func (bm *BaseModel) dbTag(tag string) string {
 	tb := bm.TableName()
 	if dt, ok := DataAccessor[tb+"::"+tag]; ok && dt != "" {
-		return gorp.Using(dt)
+		return dt
 	}
-	return gorp.Using(DBTAG)
+	return DBTAG
 }
 
 /* }}} */
@@ -800,13 +953,48 @@ func (bm *BaseModel) Valid() (Model, error) {
 		return nil, err
 	}
 	c := m.GetCtx()
+	op := 0
+	if c.Route.Creating {
+		op = HOOK_CREATE
+	} else if c.Route.Updating {
+		op = HOOK_UPDATE
+	} else if c.Route.Replacing {
+		op = HOOK_REPLACE
+		// 旧记录要在填充之前取(按url中的id), 不存在并且允许创建的按创建处理
+		if m.GetOlder() == nil && c.Route.Options.Get(KEY_UPSERT) == true {
+			op = HOOK_CREATE
+		}
+	}
+	// 补丁格式, 作用于旧记录
+	body := c.RequestBody
+	if pt := patchType(c); pt != "" && op == HOOK_UPDATE {
+		var err error
+		if body, err = bm.applyPatch(pt, body); err != nil {
+			return nil, err
+		}
+	}
+	bm.sent = sentColumns(m, body)
 	// fill model
-	if err := m.Fill(c.RequestBody); err != nil {
+	if err := m.Fill(body); err != nil {
 		return nil, err
 	}
+	return bm.validFields(op)
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) validFields(op int) (Model, error)
+ * 字段处理以及校验, op为HOOK_CREATE/HOOK_UPDATE/HOOK_REPLACE, 可以没有请求上下文(批量操作/worker)
+ * 替换时必填字段同创建, 不可编辑字段同更新
+ */
+func (bm *BaseModel) validFields(op int) (Model, error) {
+	m := bm.GetModel()
+	c := m.GetCtx()
+	creating, updating, replacing := op == HOOK_CREATE, op == HOOK_UPDATE, op == HOOK_REPLACE
 	// checker
 	checker := m.GetChecker()
 	v := reflect.ValueOf(m)
+	fe := make(FieldErrors) //校验错误一次全部返回
 	if cols := utils.ReadStructColumns(m, true); cols != nil {
 		for _, col := range cols {
 			fv := utils.FieldByIndex(v, col.Index)
@@ -814,17 +1002,29 @@ func (bm *BaseModel) Valid() (Model, error) {
 			if fv.IsValid() && !utils.IsEmptyValue(fv) { //传入了内容
 				if col.ExtOptions.Contains(TAG_GENERATE) && !col.TagOptions.Contains(DBTAG_PK) { //服务器生成, 忽略传入
 					fv.Set(reflect.Zero(fv.Type()))
-				} else if c.Route.Updating && col.ExtOptions.Contains(TAG_DENY) { //尝试编辑不可编辑的字段,要报错
+				} else if (updating || replacing) && col.ExtOptions.Contains(TAG_DENY) { //尝试编辑不可编辑的字段,要报错
 					c.Info("%s is uneditable: %v", col.Tag, fv)
 					//return nil, fmt.Errorf("%s is uneditable", col.Tag) //尝试编辑不可编辑的字段,直接报错
 					fv.Set(reflect.Zero(fv.Type()))
 				}
 			} else { //空
-				if col.ExtOptions.Contains(TAG_REQUIRED) && c.Route.Creating { // 创建时必须传入,但是为空
+				if col.ExtOptions.Contains(TAG_REQUIRED) && (creating || replacing || updating && bm.patched[col.Tag]) { // 创建/替换时必须传入,补丁不能置空, 但是为空
 					c.Debug("field %s required but empty", col.Tag)
-					return nil, fmt.Errorf("field %s required but empty", col.Tag)
+					fe[jsonName(v.Type(), col)] = "required"
+					continue
+				}
+			}
+			// 校验规则(min/max/len/enum/email/regex), 请求中没传的字段不校验
+			if absent := bm.sent != nil && !bm.sent[col.Tag]; !absent {
+				if msg := checkRules(fv, col.ExtOptions); msg != "" {
+					c.Debug("field %s invalid: %s", col.Tag, msg)
+					fe[jsonName(v.Type(), col)] = msg
+					continue
 				}
 			}
+			if len(fe) > 0 { //已经有错误, 不再做预处理
+				continue
+			}
 			switch col.ExtTag { //根据tag, 会对数据进行预处理
 			case "sha1":
 				if fv.IsValid() && !utils.IsEmptyValue(fv) { //不能为空
@@ -844,13 +1044,13 @@ func (bm *BaseModel) Valid() (Model, error) {
 					}
 				}
 			case "userid": //替换为userid,如果指定了数值
-				if c.Route.Creating && (!fv.IsValid() || utils.IsEmptyValue(fv)) {
+				if creating && (!fv.IsValid() || utils.IsEmptyValue(fv)) {
 					var userid string
-					if uid := c.GetEnv(USERID_KEY); uid == nil {
+					if uid, ok := c.GetEnv(USERID_KEY).(string); !ok {
 						userid = "0"
 						//c.Debug("userid not exists")
 					} else {
-						userid = uid.(string)
+						userid = uid
 						//c.Debug("userid: %s", userid)
 					}
 					switch fv.Type().String() {
@@ -863,7 +1063,7 @@ func (bm *BaseModel) Valid() (Model, error) {
 					}
 				}
 			case "time": //如果没有传值, 就是当前时间
-				if c.Route.Creating && (!fv.IsValid() || utils.IsEmptyValue(fv)) { //创建同时为空
+				if creating && (!fv.IsValid() || utils.IsEmptyValue(fv)) { //创建同时为空
 					now := time.Now()
 					switch fv.Type().String() {
 					case "*time.Time":
@@ -875,7 +1075,7 @@ func (bm *BaseModel) Valid() (Model, error) {
 					}
 				}
 			case "existense": //检查存在性
-				if c.Route.Creating { //创建时才检查,这里不够安全(将来改)
+				if creating { //创建时才检查,这里不够安全(将来改)
 					if exValue, err := checker(col.Tag); err != nil {
 						c.Debug("%s existense check failed: %s", col.Tag, err)
 						return nil, err
@@ -885,7 +1085,7 @@ func (bm *BaseModel) Valid() (Model, error) {
 					}
 				}
 			case "uuid":
-				if c.Route.Creating {
+				if creating {
 					switch fv.Type().String() {
 					case "*string":
 						h := utils.NewShortUUID()
@@ -898,7 +1098,7 @@ func (bm *BaseModel) Valid() (Model, error) {
 					}
 				}
 			case "luuid":
-				if c.Route.Creating {
+				if creating {
 					switch fv.Type().String() {
 					case "*string":
 						h := utils.NewUUID()
@@ -911,8 +1111,8 @@ func (bm *BaseModel) Valid() (Model, error) {
 					}
 				}
 			case "stag":
-				if c.Route.Creating { // 创建时加上内容
-					if stag := c.GetEnv(STAG_KEY).(string); stag != "" {
+				if creating { // 创建时加上内容
+					if stag, _ := c.GetEnv(STAG_KEY).(string); stag != "" {
 						switch fv.Type().String() {
 						case "*string":
 							fv.Set(reflect.ValueOf(&stag))
@@ -923,9 +1123,14 @@ func (bm *BaseModel) Valid() (Model, error) {
 						}
 					}
 				}
-			case "forbbiden": //这个字段如果旧记录有值, 则返回错误
-				if c.Route.Updating {
-					older := m.GetOlder()
+			case EXTTAG_VERSION: //版本号, 创建时为1, 更新时在UpdateRow中处理
+				if creating {
+					if err := setVersion(fv, 1); err != nil {
+						return nil, err
+					}
+				}
+			case "forbbiden": //这个字段如果旧记录有值, 则返回错误(替换时不传则保留)
+				if older := m.GetOlder(); older != nil && (updating || (replacing && fv.IsValid() && !utils.IsEmptyValue(fv))) { //批量更新没有旧记录, 不允许传这个字段
 					ov := reflect.ValueOf(older)
 					fov := utils.FieldByIndex(ov, col.Index)
 					if fov.IsValid() && !utils.IsEmptyValue(fov) {
@@ -934,16 +1139,34 @@ func (bm *BaseModel) Valid() (Model, error) {
 				}
 			default:
 				//可自定义,初始化时放到tagHooks里面
-				if col.ExtTag != "" && fv.IsValid() && !utils.IsEmptyValue(fv) { //还必须有值
+				if col.ExtTag != "" {
 					if hk := DMux.TagHooks.Get(col.ExtTag); hk != nil {
-						fv.Set(hk.(TagHook)(v))
-					} else {
+						hc := &HookContext{Ctx: c, Model: m, Column: col, Value: fv, Op: op}
+						if err := runTagHook(hk, hc); err != nil {
+							c.Debug("field %s rejected by hook: %s", col.Tag, err)
+							if efe, ok := err.(FieldErrors); ok {
+								for k, msg := range efe {
+									fe[k] = msg
+								}
+							} else {
+								fe[jsonName(v.Type(), col)] = err.Error()
+							}
+						}
+					} else if fv.IsValid() && !utils.IsEmptyValue(fv) {
 						c.Info("cannot find hook for tag: %s", col.ExtTag)
 					}
 				}
 			}
 		}
 	}
+	if len(fe) > 0 {
+		return nil, fe
+	}
+	if ps := parentScope(m); ps != nil && (creating || updating || replacing) { //嵌套路由, 父记录id以url为准
+		if err := ps.apply(m); err != nil {
+			return nil, err
+		}
+	}
 	return m, nil
 }
 
@@ -995,7 +1218,7 @@ func (bm *BaseModel) GetRow(ext ...interface{}) (Model, error) {
 	builder, _ := m.ReadPrepare()
 	ms := m.NewList()
 	var err error
-	err = builder.Select(GetDbFields(m)).Limit("1").Find(ms)
+	err = builder.Select(GetDbFields(m)).Limit(1).Find(ms)
 	if err != nil && err != sql.ErrNoRows {
 		//支持出错
 		return nil, err
@@ -1009,7 +1232,13 @@ func (bm *BaseModel) GetRow(ext ...interface{}) (Model, error) {
 		//c.Debug("len: %d, no record", resultsValue.Len())
 		return nil, ErrNoRecord
 	}
-	return BuildModel(resultsValue.Index(0).Interface().(Model), c), nil
+	r := BuildModel(resultsValue.Index(0).Interface().(Model), c)
+	if h, ok := r.(AfterFindInterface); ok {
+		if err := h.AfterFind(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
 }
 
 /* }}} */
@@ -1019,12 +1248,38 @@ func (bm *BaseModel) GetRow(ext ...interface{}) (Model, error) {
  */
 func (bm *BaseModel) CreateRow() (Model, error) {
 	if m := bm.GetModel(); m != nil {
-		db := bm.DBConn(WRITETAG)
-		if err := db.Insert(m); err != nil { //Insert会把m换成新的
+		db, err := bm.Executor(WRITETAG)
+		if err != nil {
 			return nil, err
-		} else {
-			return m.SetModel(m), nil
 		}
+		if h, ok := m.(BeforeCreateInterface); ok {
+			if err := h.BeforeCreate(); err != nil {
+				return nil, err
+			}
+		}
+		d := GetDialect(bm.dbTag(WRITETAG))
+		if pf, _, ai := m.PKey(); ai && d.Returning(pf) != "" { //pq没有LastInsertId, 自增主键用RETURNING取回
+			cols, vals := insertColumns(m)
+			id, err := NewQuery(db, d).Table(m.TableName()).Insert(cols, vals, pf)
+			if err != nil {
+				return nil, err
+			}
+			for _, col := range utils.ReadStructColumns(m, true) {
+				if col.Tag == pf {
+					if err := setFieldValue(utils.FieldByIndex(reflect.ValueOf(m), col.Index), col, id); err != nil {
+						return nil, err
+					}
+				}
+			}
+		} else if err := db.Insert(m); err != nil { //Insert会把m换成新的
+			return nil, err
+		}
+		if h, ok := m.(AfterCreateInterface); ok {
+			if err := h.AfterCreate(); err != nil {
+				return nil, err
+			}
+		}
+		return m.SetModel(m), nil
 	} else {
 		err := fmt.Errorf("not found model")
 		Info("error: %s", err)
@@ -1047,7 +1302,10 @@ func (bm *BaseModel) UpdateRow(ext ...interface{}) (affected int64, err error) {
 				id = rk
 			}
 		}
-		db := bm.DBConn(WRITETAG)
+		var db gorp.SqlExecutor
+		if db, err = bm.Executor(WRITETAG); err != nil {
+			return
+		}
 		if id != "" {
 			if err = utils.ImportValue(m, map[string]string{DBTAG_PK: id}); err != nil {
 				return
@@ -1057,7 +1315,79 @@ func (bm *BaseModel) UpdateRow(ext ...interface{}) (affected int64, err error) {
 			err = fmt.Errorf("not_found_row_to_update")
 			return
 		}
-		return db.Update(m)
+		if h, ok := m.(BeforeUpdateInterface); ok {
+			if err = h.BeforeUpdate(); err != nil {
+				return
+			}
+		}
+		// 乐观锁
+		if err = bm.checkVersion(db, id); err != nil {
+			return
+		}
+		if bm.patched != nil { //补丁, 只写有变化的字段(可以置空), 包括钩子修改的
+			bm.markChanged()
+			if affected, err = bm.writeColumns(db, id, bm.patched); err != nil {
+				return
+			}
+		} else if affected, err = db.Update(m); err != nil {
+			return
+		}
+		if h, ok := m.(AfterUpdateInterface); ok {
+			err = h.AfterUpdate()
+		}
+		return
+	} else {
+		err = fmt.Errorf("not_found_model")
+		return
+	}
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) ReplaceRow(ext ...interface{}) (affected int64, err error)
+ * 替换record, 没有传入的字段恢复为默认值, 传入null的置空
+ * 主键、服务端生成(G)、不可编辑(D)、隐藏(H)、logic字段以及没有传入的密码(sha1)、创建者(userid)保持不变
+ */
+func (bm *BaseModel) ReplaceRow(ext ...interface{}) (affected int64, err error) {
+	if m := bm.GetModel(); m != nil {
+		var id string
+		if len(ext) > 0 {
+			if rk, ok := ext[0].(string); ok && rk != "" {
+				id = rk
+			}
+		}
+		if _, pv, _ := m.PKey(); id == "" && pv != "" {
+			id = pv
+		}
+		var db gorp.SqlExecutor
+		if db, err = bm.Executor(WRITETAG); err != nil {
+			return
+		}
+		if id != "" {
+			if err = utils.ImportValue(m, map[string]string{DBTAG_PK: id}); err != nil {
+				return
+			}
+		} else {
+			Info("not_found_row")
+			err = fmt.Errorf("not_found_row_to_replace")
+			return
+		}
+		if h, ok := m.(BeforeUpdateInterface); ok {
+			if err = h.BeforeUpdate(); err != nil {
+				return
+			}
+		}
+		// 乐观锁, 版本号会写入m
+		if err = bm.checkVersion(db, id); err != nil {
+			return
+		}
+		if affected, err = bm.writeColumns(db, id, nil); err != nil {
+			return
+		}
+		if h, ok := m.(AfterUpdateInterface); ok {
+			err = h.AfterUpdate()
+		}
+		return
 	} else {
 		err = fmt.Errorf("not_found_model")
 		return
@@ -1066,16 +1396,132 @@ func (bm *BaseModel) UpdateRow(ext ...interface{}) (affected int64, err error) {
 
 /* }}} */
 
+/* {{{ func (bm *BaseModel) writeColumns(db gorp.SqlExecutor, id string, only map[string]bool) (int64, error)
+ * UPDATE t SET ... WHERE pk = ?, 空值也写入(指针为NULL); only不为nil时只写其中的字段
+ */
+func (bm *BaseModel) writeColumns(db gorp.SqlExecutor, id string, only map[string]bool) (int64, error) {
+	m := bm.GetModel()
+	d := GetDialect(bm.dbTag(WRITETAG))
+	pf, _, _ := m.PKey()
+	sets, args, err := bm.replaceColumns(db, d, only)
+	if err != nil {
+		return 0, err
+	}
+	if len(sets) == 0 {
+		return 0, nil
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", d.Quote(m.TableName()), strings.Join(sets, ", "), d.Quote(pf))
+	r, err := db.Exec(Rebind(d, query), append(args, id)...)
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) replaceColumns(db gorp.SqlExecutor, d Dialect, only map[string]bool) ([]string, []interface{}, error)
+ * 替换时要写的字段以及值
+ */
+func (bm *BaseModel) replaceColumns(db gorp.SqlExecutor, d Dialect, only map[string]bool) ([]string, []interface{}, error) {
+	m := bm.GetModel()
+	v := reflect.ValueOf(m)
+	sets := make([]string, 0)
+	args := make([]interface{}, 0)
+	for _, col := range utils.ReadStructColumns(m, true) {
+		if col.Tag == "-" || col.TagOptions.Contains(DBTAG_PK) || col.TagOptions.Contains(DBTAG_LOGIC) {
+			continue
+		}
+		if only != nil && !only[col.Tag] && col.ExtTag != EXTTAG_VERSION {
+			continue
+		}
+		fv := utils.FieldByIndex(v, col.Index)
+		if !fv.IsValid() || !fv.CanInterface() {
+			continue
+		}
+		if col.ExtTag != EXTTAG_VERSION { //版本号已经在checkVersion中设置
+			if col.ExtOptions.Contains(TAG_GENERATE) || col.ExtOptions.Contains(TAG_DENY) || col.ExtOptions.Contains(TAG_HIDDEN) {
+				continue
+			}
+			if col.ExtTag == "forbbiden" && utils.IsEmptyValue(fv) { //不传则保留
+				continue
+			}
+			// 没有请求上下文(直接调用ReplaceRow)时, 有值的算传入
+			if sent := bm.sent[col.Tag] || bm.sent == nil && !utils.IsEmptyValue(fv); only == nil && !sent {
+				if col.ExtTag == "sha1" || col.ExtTag == "userid" { //密码/创建者不传则保留
+					continue
+				}
+				if utils.IsEmptyValue(fv) { //钩子也没有设置, 恢复默认值
+					def, err := d.ColumnDefault(db, m.TableName(), col.Tag)
+					if err != nil {
+						return nil, nil, err
+					}
+					sets = append(sets, d.Quote(col.Tag)+" = "+def)
+					continue
+				}
+			}
+		} else if utils.IsEmptyValue(fv) {
+			continue
+		}
+		sets = append(sets, d.Quote(col.Tag)+" = ?")
+		args = append(args, fv.Interface())
+	}
+	return sets, args, nil
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) setSent(sent map[string]bool)
+ * 没有请求内容时指定传了的字段(批量更新)
+ */
+func (bm *BaseModel) setSent(sent map[string]bool) {
+	bm.sent = sent
+}
+
+/* }}} */
+
+/* {{{ func sentColumns(m Model, body []byte) map[string]bool
+ * 请求内容(json对象)中出现的字段, 值为null也算
+ */
+func sentColumns(m Model, body []byte) map[string]bool {
+	keys := make(map[string]json.RawMessage)
+	json.Unmarshal(body, &keys)
+	t := reflect.TypeOf(m)
+	sent := make(map[string]bool)
+	for _, col := range utils.ReadStructColumns(m, true) {
+		if _, ok := keys[jsonName(t, col)]; ok {
+			sent[col.Tag] = true
+		}
+	}
+	return sent
+}
+
+/* }}} */
+
 /* {{{ func (bm *BaseModel) DeleteRow(id string) (affected int64, err error)
  * 删除记录(逻辑删除)
  */
 func (bm *BaseModel) DeleteRow(id string) (affected int64, err error) {
 	if m := bm.GetModel(); m != nil {
-		db := bm.DBConn(WRITETAG)
+		var db gorp.SqlExecutor
+		if db, err = bm.Executor(WRITETAG); err != nil {
+			return
+		}
 		if err = utils.ImportValue(m, map[string]string{DBTAG_PK: id, DBTAG_LOGIC: "-1"}); err != nil {
 			return
 		}
-		return db.Update(m)
+		if h, ok := m.(BeforeDeleteInterface); ok {
+			if err = h.BeforeDelete(); err != nil {
+				return
+			}
+		}
+		if affected, err = db.Update(m); err != nil {
+			return
+		}
+		if h, ok := m.(AfterDeleteInterface); ok {
+			err = h.AfterDelete()
+		}
+		return
 	} else {
 		err := fmt.Errorf("not found model")
 		Info("error: %s", err)
@@ -1093,6 +1539,9 @@ func (bm *BaseModel) GetRows() (l *List, err error) {
 		c := m.GetCtx()
 		l = new(List)
 		builder, _ := bm.ReadPrepare()
+		if p := bm.GetPagination(); p != nil && p.Cursor != nil { //游标分页
+			return bm.getRowsByCursor(builder, p, l)
+		}
 		count, _ := builder.Count() //结果数
 		ms := bm.NewList()
 		if p := bm.GetPagination(); p != nil {
@@ -1111,6 +1560,10 @@ func (bm *BaseModel) GetRows() (l *List, err error) {
 			return l, ErrNoRecord
 		}
 
+		if err = afterFindList(ms); err != nil {
+			return l, err
+		}
+
 		l.Total = count
 		l.List = ms
 
@@ -1124,6 +1577,79 @@ func (bm *BaseModel) GetRows() (l *List, err error) {
 
 /* }}} */
 
+/* {{{ func (bm *BaseModel) getRowsByCursor(builder *Query, p *Pagination, l *List) (*List, error)
+ * 游标分页, 多取一条判断是否有下一页, 默认不计算总数(Total为-1)
+ */
+func (bm *BaseModel) getRowsByCursor(builder *Query, p *Pagination, l *List) (*List, error) {
+	m := bm.GetModel()
+	c := m.GetCtx()
+	l.Total = -1
+	if p.WithTotal {
+		l.Total, _ = builder.Count()
+	}
+	if ws, args, err := p.Cursor.where(m, bm.orders, builder.Dialect()); err != nil {
+		return l, err
+	} else if ws != "" {
+		builder.Where(ws, args...)
+	}
+	if p.PerPage < 1 {
+		p.PerPage = _DEF_PER_PAGE
+	}
+	l.Info.PerPage = &p.PerPage
+	// 排序字段总要取出来生成游标, fields中没有的返回前清空
+	fs := GetDbFields(m, true)
+	extras := make([]string, 0)
+	for _, ob := range bm.orders {
+		if !utils.InSlice(ob.Field, fs) {
+			fs = append(fs, ob.Field)
+			extras = append(extras, ob.Field)
+		}
+	}
+	ms := bm.NewList()
+	if err := builder.Select(fs).Limit(p.PerPage + 1).Find(ms); err != nil && err != sql.ErrNoRows {
+		return l, err
+	}
+	rows := reflect.ValueOf(ms).Elem()
+	if rows.Len() > p.PerPage { //还有下一页
+		rows.Set(rows.Slice(0, p.PerPage))
+		cur, err := newCursor(rows.Index(p.PerPage-1), bm.orders)
+		if err != nil {
+			return l, err
+		}
+		l.Info.NextCursor = cur.String()
+	}
+	if len(extras) > 0 {
+		clearColumns(rows, extras)
+	}
+	c.Debug("[cursor: %v][per_page: %d][next: %s]", p.Cursor.Values, p.PerPage, l.Info.NextCursor)
+	if err := afterFindList(ms); err != nil {
+		return l, err
+	}
+	l.List = ms
+	return l, nil
+}
+
+/* }}} */
+
+/* {{{ func clearColumns(rows reflect.Value, tags []string)
+ * 清空列表中这些字段
+ */
+func clearColumns(rows reflect.Value, tags []string) {
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i)
+		for _, col := range utils.ReadStructColumns(row.Interface(), true) {
+			if !utils.InSlice(col.Tag, tags) {
+				continue
+			}
+			if fv := utils.FieldByIndex(row, col.Index); fv.IsValid() && fv.CanSet() {
+				fv.Set(reflect.Zero(fv.Type()))
+			}
+		}
+	}
+}
+
+/* }}} */
+
 /* {{{ func (bm *BaseModel) GetSum(d []string) (l *List, err error)
  * 获取list, 通用函数
  */
@@ -1134,9 +1660,10 @@ func (bm *BaseModel) GetSum(d []string) (l *List, err error) {
 
 		l = new(List)
 
+		dl := builder.Dialect()
 		group := make([]string, 0)
 		ms := bm.NewList()
-		if err := builder.Select(GetSumFields(m, group)).Find(ms); err == nil {
+		if err := builder.Select(GetSumFields(m, group, dl)).Find(ms); err == nil {
 			sumValue := reflect.Indirect(reflect.ValueOf(ms))
 			if sumValue.Len() > 0 {
 				l.Info.Sum = sumValue.Index(0).Interface()
@@ -1150,7 +1677,7 @@ func (bm *BaseModel) GetSum(d []string) (l *List, err error) {
 
 		ms = bm.NewList()
 
-		if err = builder.Select(GetSumFields(m, group)).Find(ms); err != nil {
+		if err = builder.Select(GetSumFields(m, group, dl)).Find(ms); err != nil {
 			return l, err
 		} else if ms == nil {
 			return l, ErrNoRecord
@@ -1194,6 +1721,23 @@ func (bm *BaseModel) GetCountNSum() (cnt int64, sum float64) {
 
 /* }}} */
 
+/* {{{ func (bm *BaseModel) rowExists(id string) (bool, error)
+ * 主键为id的记录是否存在, 不受条件、父资源以及逻辑删除的限制
+ */
+func (bm *BaseModel) rowExists(id string) (bool, error) {
+	m := bm.GetModel()
+	db, err := bm.Executor(WRITETAG)
+	if err != nil {
+		return false, err
+	}
+	d := GetDialect(bm.dbTag(WRITETAG))
+	pf, _, _ := m.PKey()
+	n, err := db.SelectInt(Rebind(d, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = ?", d.Quote(m.TableName()), d.Quote(pf))), id)
+	return n > 0, err
+}
+
+/* }}} */
+
 /* {{{ func (bm *BaseModel) GetOlder() Model
  * 获取旧记录
  */
@@ -1276,20 +1820,46 @@ func (bm *BaseModel) AddTable(tags ...string) {
 
 /* }}} */
 
-/* {{{ func (bm *BaseModel) ReadPrepare() (b *gorp.Builder, err error)
+/* {{{ func (bm *BaseModel) ReadPrepare() (b *Query, err error)
  * 查询准备
  */
-func (bm *BaseModel) ReadPrepare() (b *gorp.Builder, err error) {
+func (bm *BaseModel) ReadPrepare() (b *Query, err error) {
 	var m Model
 	if m = bm.GetModel(); m == nil {
 		err := fmt.Errorf("not found model")
 		Info("error: %s", err)
 		return nil, err
 	}
-	db := bm.DBConn(READTAG)
+	db, d := bm.reader()
+	qc := func(f string) string { return "T." + d.Quote(f) }
 	tb := bm.TableName()
-	b = gorp.NewBuilder(db).Table(tb)
+	b = NewQuery(db, d).Table(tb)
+	if ps := parentScope(m); ps != nil { //嵌套路由, 限定在父记录之下
+		b.Where(qc(ps.Field)+" = ?", ps.Value)
+	}
 	cons := bm.GetConditions()
+	cols := utils.ReadStructColumns(m, true)
+	// 排序, 同一字段只取第一次
+	bm.orders = make([]*OrderBy, 0)
+	addOrder := func(field, sort string) {
+		if field == "" {
+			return
+		}
+		for _, ob := range bm.orders {
+			if ob.Field == field {
+				return
+			}
+		}
+		if sort = strings.ToUpper(sort); sort != "ASC" && sort != "DESC" { //字段默认
+			sort = "DESC"
+			for _, col := range cols {
+				if col.Tag == field && col.ExtOptions.Contains(TAG_AORDERBY) {
+					sort = "ASC"
+				}
+			}
+		}
+		bm.orders = append(bm.orders, &OrderBy{Field: field, Sort: sort})
+	}
 
 	// condition
 	if len(cons) > 0 {
@@ -1300,9 +1870,9 @@ func (bm *BaseModel) ReadPrepare() (b *gorp.Builder, err error) {
 				//Debug("[perpare]timerange")
 				switch vt := v.Range.(type) {
 				case *TimeRange: //只支持timerange
-					b.Where(fmt.Sprintf("T.`%s` BETWEEN ? AND ?", v.Field), vt.Start, vt.End)
+					b.Where(qc(v.Field)+" BETWEEN ? AND ?", vt.Start, vt.End)
 				case TimeRange: //只支持timerange
-					b.Where(fmt.Sprintf("T.`%s` BETWEEN ? AND ?", v.Field), vt.Start, vt.End)
+					b.Where(qc(v.Field)+" BETWEEN ? AND ?", vt.Start, vt.End)
 				default:
 					//nothing
 				}
@@ -1311,58 +1881,46 @@ func (bm *BaseModel) ReadPrepare() (b *gorp.Builder, err error) {
 			if v.Order != nil {
 				switch vt := v.Order.(type) {
 				case *OrderBy:
-					b.Order(fmt.Sprintf("T.`%s` %s", vt.Field, vt.Sort))
+					addOrder(vt.Field, vt.Sort)
 				case OrderBy:
-					b.Order(fmt.Sprintf("T.`%s` %s", vt.Field, vt.Sort))
+					addOrder(vt.Field, vt.Sort)
+				case []*OrderBy:
+					for _, ob := range vt {
+						addOrder(ob.Field, ob.Sort)
+					}
 				default:
 					//nothing
 				}
 			}
 		}
 		jc := 0
+		orKeys := make([]string, 0)
 		orCons := make(map[string][]string)
+		orArgs := make(map[string][]interface{})
 		for _, v := range cons {
 			//Debug("[key: %s]%v", v.Field, v)
-			v.DoWhere(b) //已经处理了 raw/is/not/like/gt/lt
+			v.DoWhere(b, d) //已经处理了 raw/is/not/like/gt/lt
 			if v.Or != nil {
 				//Debug("[OR][key: %s]%v", v.Field, v)
 				oc := v.Or.(*Condition)
 				orKey := oc.Field
 				if orCons[orKey] == nil {
+					orKeys = append(orKeys, orKey)
 					orCons[orKey] = make([]string, 0)
 				}
 				//Debug("or condition: %s", orKey)
-				switch ot := oc.Is.(type) {
-				case string:
-					//Debug("or condition: %s, field: %s", orKey, v.Field)
-					orCons[orKey] = append(orCons[orKey], fmt.Sprintf("T.`%s` = '%s'", v.Field, ot))
-				case []string:
-					vs := bytes.Buffer{}
-					first := true
-					vs.WriteString("(")
-					for _, vv := range ot {
-						if !first {
-							vs.WriteString(",")
+				if oc.Is != nil {
+					if vs, multi := condValues(oc.Is); multi {
+						if len(vs) > 0 {
+							orCons[orKey] = append(orCons[orKey], fmt.Sprintf("%s IN (%s)", qc(v.Field), placeholders(len(vs))))
+							orArgs[orKey] = append(orArgs[orKey], vs...)
+						} else {
+							orCons[orKey] = append(orCons[orKey], "1=0")
 						}
-						vs.WriteString(fmt.Sprintf("'%s'", vv))
-						first = false
-					}
-					vs.WriteString(")")
-					orCons[orKey] = append(orCons[orKey], fmt.Sprintf("T.`%s` IN %s", v.Field, vs.String()))
-				case []interface{}:
-					vs := bytes.Buffer{}
-					first := true
-					vs.WriteString("(")
-					for _, vv := range ot {
-						if !first {
-							vs.WriteString(",")
-						}
-						vs.WriteString(fmt.Sprintf("'%s'", vv))
-						first = false
+					} else {
+						orCons[orKey] = append(orCons[orKey], qc(v.Field)+" = ?")
+						orArgs[orKey] = append(orArgs[orKey], vs...)
 					}
-					vs.WriteString(")")
-					orCons[orKey] = append(orCons[orKey], fmt.Sprintf("T.`%s` IN %s", v.Field, vs.String()))
-				default:
 				}
 			}
 			if v.Join != nil { //关联查询
@@ -1387,9 +1945,9 @@ func (bm *BaseModel) ReadPrepare() (b *gorp.Builder, err error) {
 							}
 						}
 						if canJoin {
-							js := fmt.Sprintf("LEFT JOIN `%s` T%d ON T.`%s` = T%d.`id`", jt, jc, v.Field, jc)
+							js := fmt.Sprintf("LEFT JOIN %s T%d ON %s = T%d.%s", d.Quote(jt), jc, qc(v.Field), jc, d.Quote("id"))
 							b.Joins(js)
-							b.Where(fmt.Sprintf("T%d.`%s`=?", jc, jf), vt.Is.(string))
+							b.Where(fmt.Sprintf("T%d.%s=?", jc, d.Quote(jf)), vt.Is.(string))
 							jc++
 						}
 					}
@@ -1398,9 +1956,9 @@ func (bm *BaseModel) ReadPrepare() (b *gorp.Builder, err error) {
 				}
 			}
 		}
-		if len(orCons) > 0 {
-			for _, css := range orCons {
-				b.Where("(" + strings.Join(css, " OR ") + ")")
+		for _, orKey := range orKeys {
+			if css := orCons[orKey]; len(css) > 0 {
+				b.Where("("+strings.Join(css, " OR ")+")", orArgs[orKey]...)
 			}
 		}
 	} else { //没有条件从自身找
@@ -1412,32 +1970,49 @@ func (bm *BaseModel) ReadPrepare() (b *gorp.Builder, err error) {
 				if (col.TagOptions.Contains(DBTAG_PK) || col.ExtOptions.Contains(TAG_CONDITION)) && fv.IsValid() && !utils.IsEmptyValue(fv) { //有值
 					if fs := utils.GetRealString(fv); fs != "" {
 						// 多个字段有值, 用AND
-						b.Where(fmt.Sprintf("T.`%s` = ?", col.Tag), fs)
+						b.Where(qc(col.Tag)+" = ?", fs)
 					}
 				}
 			}
 		}
 	}
 
-	if cols := utils.ReadStructColumns(m, true); cols != nil {
+	if cols != nil {
 		pks := ""
+		explicit := len(bm.orders) > 0
 		for _, col := range cols {
-			//处理排序问题,如果之前有排序，这里就是二次排序,如果之前无排序,这里是首要排序
+			//请求中指定了排序则替代字段默认排序, pk始终作为最后的排序
 			if col.TagOptions.Contains(DBTAG_PK) { // 默认为pk降序
-				pks = fmt.Sprintf("T.`%s` DESC", col.Tag)
+				pks = col.Tag
+			} else if explicit {
+				//nothing
 			} else if col.ExtOptions.Contains(TAG_ORDERBY) { // 默认为降序
-				b.Order(fmt.Sprintf("T.`%s` DESC", col.Tag))
+				addOrder(col.Tag, "DESC")
 			} else if col.ExtOptions.Contains(TAG_AORDERBY) { //正排序
-				b.Order(fmt.Sprintf("T.`%s` ASC", col.Tag))
+				addOrder(col.Tag, "ASC")
 			}
 			// 处理逻辑删除
 			if col.TagOptions.Contains(DBTAG_LOGIC) {
-				b.Where(fmt.Sprintf("T.`%s` != -1", col.Tag))
+				b.Where(qc(col.Tag) + " != -1")
 			}
 		}
 		if pks != "" { //pk排序放到最后
-			b.Order(pks)
+			addOrder(pks, "DESC")
+		}
+	}
+	cursor := false
+	if p := bm.GetPagination(); p != nil && p.Cursor != nil {
+		cursor = true
+	}
+	for _, ob := range bm.orders {
+		if cursor { //游标分页, NULL排在最后(各数据库默认不一样)
+			for _, col := range cols {
+				if col.Tag == ob.Field && nullableColumn(col) {
+					b.Order(qc(ob.Field) + " IS NULL")
+				}
+			}
 		}
+		b.Order(qc(ob.Field) + " " + ob.Sort)
 	}
 
 	return
@@ -1497,10 +2072,11 @@ func GetDbFields(i interface{}, ops ...interface{}) (s []string) {
 
 /* }}} */
 
-/* {{{ func GetSumFields(i interface{}, g []string) (s string)
- * 从struct中解析数据库字段以及字段选项,为了报表
+/* {{{ func GetSumFields(i interface{}, g []string, ds ...Dialect) (s string)
+ * 从struct中解析数据库字段以及字段选项,为了报表; 方言默认mysql
  */
-func GetSumFields(i interface{}, g []string) (s string) {
+func GetSumFields(i interface{}, g []string, ds ...Dialect) (s string) {
+	d := dialectOf(ds)
 	if cols := utils.ReadStructColumns(i, true); cols != nil {
 		bs := bytes.Buffer{}
 		first := true
@@ -1518,14 +2094,14 @@ func GetSumFields(i interface{}, g []string) (s string) {
 				bs.WriteString(",")
 			}
 			if col.ExtOptions.Contains(TAG_SUM) {
-				bs.WriteString(fmt.Sprintf("SUM(T.`%s`) AS `%s`", col.Tag, col.Tag))
+				bs.WriteString(fmt.Sprintf("SUM(T.%s) AS %s", d.Quote(col.Tag), d.Quote(col.Tag)))
 				if col.ExtOptions.Contains(TAG_TSUM) {
-					bs.WriteString(fmt.Sprintf(",SUM(T.`%s`) AS `%s`", col.Tag, EXF_SUM))
+					bs.WriteString(fmt.Sprintf(",SUM(T.%s) AS %s", d.Quote(col.Tag), d.Quote(EXF_SUM)))
 				}
 			} else if col.ExtOptions.Contains(TAG_COUNT) {
-				bs.WriteString(fmt.Sprintf("COUNT(T.`%s`) AS `%s`", col.Tag, EXF_COUNT))
+				bs.WriteString(fmt.Sprintf("COUNT(T.%s) AS %s", d.Quote(col.Tag), d.Quote(EXF_COUNT)))
 			} else {
-				bs.WriteString("T.`" + col.Tag + "`")
+				bs.WriteString("T." + d.Quote(col.Tag))
 			}
 			first = false
 		}
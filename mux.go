@@ -24,24 +24,26 @@ import (
 /* {{{ type Environ struct
  */
 type Environ struct {
-	lock          *sync.RWMutex
-	WorkPath      string         // working path(abs)
-	AppPath       string         // application path
-	ProcName      string         // proc name
-	Worker        string         // worker name
-	AppConfigPath string         // config file path
-	RunMode       string         // run mode, "dev" or "prod"
-	AccessPath    string         // acces log file path
-	TplDir        string         // tpl dir
-	Daemonize     bool           // daemonize or not
-	EnableGzip    bool           // enable gzip or not
-	DebugLevel    int            // debug level
-	PidFile       string         // pidfile abs path
-	Port          string         // http port
-	IndentJSON    bool           // indent JSON
-	MaxMemory     int64          //max memory(form-data)
-	Location      *time.Location // location
-	initErr       error
+	lock            *sync.RWMutex
+	WorkPath        string         // working path(abs)
+	AppPath         string         // application path
+	ProcName        string         // proc name
+	Worker          string         // worker name
+	AppConfigPath   string         // config file path
+	RunMode         string         // run mode, "dev" or "prod"
+	AccessPath      string         // acces log file path
+	TplDir          string         // tpl dir
+	Daemonize       bool           // daemonize or not
+	EnableGzip      bool           // enable gzip or not
+	DebugLevel      int            // debug level
+	PidFile         string         // pidfile abs path
+	Port            string         // http port
+	IndentJSON      bool           // indent JSON
+	OpenAPI         bool           // GET /@openapi.json
+	MaxMemory       int64          //max memory(form-data)
+	Location        *time.Location // location
+	ShutdownTimeout time.Duration  // 平滑关闭时等待请求结束的最长时间
+	initErr         error
 }
 
 /* }}} */
@@ -60,6 +62,10 @@ type Mux struct {
 	Hooks    HStack
 	//TagHooks map[string]TagHook
 	TagHooks *utils.SafeMap
+	mwLock   sync.Mutex
+	prefixes []*prefixMiddleware // 路径前缀中间件
+	rtOnce   sync.Once
+	rtable   *routeTable // 按pattern分组的路由, Run时生成(OPTIONS/跨域用)
 }
 
 /* }}} */
@@ -70,10 +76,6 @@ func New() *Mux {
 	return &Mux{
 		Workers: make(map[string]*Worker),
 		Routes:  make(map[string]*Route),
-		Hooks: HStack{
-			preHooks:  make([]OgoHook, 0),
-			postHooks: make([]OgoHook, 0),
-		},
 		//TagHooks: make(map[string]TagHook),
 		TagHooks: utils.NewSafeMap(),
 	}
@@ -87,7 +89,7 @@ func New() *Mux {
 func (mux *Mux) PreHook(hook OgoHook) {
 	mux.Hooks.lock.Lock()
 	defer mux.Hooks.lock.Unlock()
-	mux.Hooks.preHooks = append(mux.Hooks.preHooks, hook)
+	appendHook(&mux.Hooks.preHooks, hook)
 }
 
 /* }}} */
@@ -98,7 +100,7 @@ func (mux *Mux) PreHook(hook OgoHook) {
 func (mux *Mux) PostHook(hook OgoHook) {
 	mux.Hooks.lock.Lock()
 	defer mux.Hooks.lock.Unlock()
-	mux.Hooks.postHooks = append(mux.Hooks.postHooks, hook)
+	appendHook(&mux.Hooks.postHooks, hook)
 }
 
 /* }}} */
@@ -112,6 +114,15 @@ func (mux *Mux) AddTagHook(tag string, hook TagHook) {
 
 /* }}} */
 
+/* {{{ func (mux *Mux) AddContextHook(tag string, hook ContextHook, onEmpty bool)
+ * 带上下文的tag钩子, onEmpty为true时字段为空也执行
+ */
+func (mux *Mux) AddContextHook(tag string, hook ContextHook, onEmpty bool) {
+	mux.TagHooks.Set(tag, contextHook{hook: hook, onEmpty: onEmpty})
+}
+
+/* }}} */
+
 /* {{{ func (mux *Mux) NewRouter(c interface{}, endpoint string) RouterInterface
  * 这样做的目的是给rounter设置mux(mux可多个) -- mux=multiplexer,复用器
  */
@@ -141,6 +152,7 @@ func (mux *Mux) Env() (*Environ, error) {
 		env.IndentJSON = false
 		env.MaxMemory = 1 << 32                              // 4GB
 		env.Location, _ = time.LoadLocation("Asia/Shanghai") //默认上海时区
+		env.ShutdownTimeout = DEFAULT_SHUTDOWN_TIMEOUT * time.Second
 
 		workPath, _ := os.Getwd()
 		env.WorkPath, _ = filepath.Abs(workPath)
@@ -225,6 +237,9 @@ func (mux *Mux) initEnv() (err error) {
 	if indentJson, err := cfg.Bool("IndentJson"); err == nil {
 		env.IndentJSON = indentJson
 	}
+	if openAPI, err := cfg.Bool("OpenAPI"); err == nil {
+		env.OpenAPI = openAPI
+	}
 	// 自定义pidfile
 	if pidfile := cfg.String("PidFile"); pidfile != "" {
 		// make sure pidfile is abs path
@@ -241,6 +256,9 @@ func (mux *Mux) initEnv() (err error) {
 	if level, err := cfg.Int("DebugLevel"); err == nil {
 		env.DebugLevel = level
 	}
+	if timeout, err := cfg.Int("ShutdownTimeout"); err == nil && timeout >= 0 {
+		env.ShutdownTimeout = time.Duration(timeout) * time.Second
+	}
 
 	// logger init
 	if logger, err = mux.Logger(); err != nil {
@@ -259,7 +277,7 @@ func (mux *Mux) initEnv() (err error) {
 
 	// redis cluster
 
-	//db init,目前只有mysql
+	//db init, 支持mysql/postgres/sqlite, 见ParseDSN
 	if dns := cfg.String("data::dns"); dns != "" {
 		OpenDB(DBTAG, dns)
 	}
@@ -0,0 +1,130 @@
+/* 嵌套资源, /{parent}/:_prk_/{child}[/:_rk_] */
+package ogo
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/Odinman/ogo/utils"
+)
+
+// 父资源范围, 子资源的读写都限定在父记录之下
+type ParentScope struct {
+	Table string // 子表
+	Field string // 子表中指向父记录的字段
+	Value string // 父记录id
+}
+
+/* {{{ func parentScope(m Model) *ParentScope
+ * 当前请求中属于这个model的父资源范围
+ */
+func parentScope(m Model) *ParentScope {
+	c := m.GetCtx()
+	if ps, ok := c.GetEnv(ParentKey).(*ParentScope); ok && ps.Table == m.TableName() {
+		return ps
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (ps *ParentScope) apply(m Model) error
+ * 把父记录id写入子记录(创建/更新时, 忽略传入的值)
+ */
+func (ps *ParentScope) apply(m Model) error {
+	for _, col := range utils.ReadStructColumns(m, true) {
+		if col.Tag != ps.Field {
+			continue
+		}
+		fv := utils.FieldByIndex(reflect.ValueOf(m), col.Index)
+		if !fv.IsValid() || !fv.CanSet() {
+			return fmt.Errorf("field(%s) can't be set", col.Tag)
+		}
+		v, err := convertValue(col.Type, ps.Value)
+		if err != nil {
+			return FieldErrors{col.Tag: err.Error()}
+		}
+		rv := reflect.ValueOf(v)
+		if fv.Kind() == reflect.Ptr {
+			pv := reflect.New(fv.Type().Elem())
+			pv.Elem().Set(rv.Convert(fv.Type().Elem()))
+			fv.Set(pv)
+		} else {
+			fv.Set(rv.Convert(fv.Type()))
+		}
+		return nil
+	}
+	return fmt.Errorf("not found field: %s", ps.Field)
+}
+
+/* }}} */
+
+/* {{{ func (rtr *Router) nested(parent Model, i interface{}, fk string, h Handler) Handler
+ * 父记录不存在返回404; 带子记录id的请求, 子记录必须属于父记录
+ */
+func (rtr *Router) nested(parent Model, i interface{}, fk string, h Handler) Handler {
+	return func(c *RESTContext) {
+		pid := c.URLParams[ParentRowkeyKey]
+		if _, err := NewModel(parent, c).GetRow(pid); err == ErrNoRecord {
+			c.RESTNotFound(fmt.Errorf("parent not found: %s", pid))
+			return
+		} else if err != nil {
+			c.RESTPanic(err)
+			return
+		}
+		c.SetEnv(ParentKey, &ParentScope{Table: i.(Model).TableName(), Field: fk, Value: pid})
+		if id := c.URLParams[RowkeyKey]; id != "" && c.Request.Method != "GET" {
+			if _, err := NewModel(i.(Model), c).GetRow(id); err == ErrNoRecord {
+				if c.Route.Replacing && c.Route.Options.Get(KEY_UPSERT) == true { //PUT创建
+					h(c)
+					return
+				}
+				c.RESTNotFound(err)
+				return
+			} else if err != nil {
+				c.RESTPanic(err)
+				return
+			}
+		}
+		h(c)
+	}
+}
+
+/* }}} */
+
+/* {{{ func (rtr *Router) NestedRoute(parent string, pm Model, i interface{}, fk string, flag int, options ...RouteOption)
+ * 子资源路由, parent为父资源的endpoint, pm为父model, fk为子model中指向父记录的字段
+ * 读取时自动加上fk条件, 创建/更新时fk设为父记录id
+ */
+func (rtr *Router) NestedRoute(parent string, pm Model, i interface{}, fk string, flag int, options ...RouteOption) {
+	base := "/" + parent + "/:" + ParentRowkeyKey + "/" + rtr.GetEndpoint()
+	item := base + "/:" + RowkeyKey
+	if flag&GA_HEAD > 0 {
+		// HEAD /{parent}/{pid}/{endpoint}
+		rtr.AddRoute("HEAD", base, rtr.nested(pm, i, fk, rtr.CRUD(i, GA_HEAD)), mergeOptions(RouteOption{KEY_SKIPLOGIN: true, KEY_MODEL: i}, options...))
+	}
+	if flag&GA_GET > 0 {
+		// GET /{parent}/{pid}/{endpoint}
+		rtr.AddRoute("GET", base, rtr.nested(pm, i, fk, rtr.CRUD(i, GA_SEARCH)), mergeOptions(RouteOption{KEY_MODEL: i}, options...))
+		// GET /{parent}/{pid}/{endpoint}/{id}
+		rtr.AddRoute("GET", item, rtr.nested(pm, i, fk, rtr.CRUD(i, GA_GET)), mergeOptions(RouteOption{KEY_MODEL: i}, options...))
+	}
+	if flag&GA_POST > 0 {
+		// POST /{parent}/{pid}/{endpoint}
+		rtr.AddRoute("POST", base, rtr.nested(pm, i, fk, rtr.CRUD(i, GA_POST)), mergeOptions(RouteOption{KEY_MODEL: i}, options...))
+	}
+	if flag&GA_DELETE > 0 {
+		// DELETE /{parent}/{pid}/{endpoint}/{id}
+		rtr.AddRoute("DELETE", item, rtr.nested(pm, i, fk, rtr.CRUD(i, GA_DELETE)), mergeOptions(RouteOption{KEY_MODEL: i}, options...))
+	}
+	if flag&GA_PATCH > 0 {
+		// PATCH /{parent}/{pid}/{endpoint}/{id}
+		rtr.AddRoute("PATCH", item, rtr.nested(pm, i, fk, rtr.CRUD(i, GA_PATCH)), mergeOptions(RouteOption{KEY_MODEL: i}, options...))
+	}
+	if flag&GA_PUT > 0 {
+		// PUT /{parent}/{pid}/{endpoint}/{id}
+		rtr.AddRoute("PUT", item, rtr.nested(pm, i, fk, rtr.CRUD(i, GA_PUT)), mergeOptions(RouteOption{KEY_MODEL: i}, options...))
+	}
+}
+
+/* }}} */
@@ -71,6 +71,32 @@ const (
 
 /* }}} */
 
+/* {{{ const
+ * 后来增加的, 单独放, 不影响上面iota的值
+ */
+const (
+	DEFAULT_SHUTDOWN_TIMEOUT = 30 // 平滑关闭等待时间(秒)
+
+	// 需要单独开启的generic action, 不在GA_ALL里
+	GA_BATCH = GA_HEAD << 1 // POST /{endpoint}/@batch
+	GA_PUT   = GA_HEAD << 2 // PUT /{endpoint}/{id}, 全量替换
+
+	KEY_CONDITIONAL = "conditional" // 条件GET(ETag/Last-Modified), 默认开启
+	KEY_MODEL       = "model"       // 路由对应的model, 用于生成OpenAPI文档
+	KEY_MIDDLEWARE  = "middleware"  // 路由的中间件, Middleware或者[]Middleware
+	KEY_UPSERT      = "upsert"      // PUT的记录不存在时创建(201), 默认返回404
+	KEY_CORS        = "cors"        // 路由的跨域设置, *CORS, false为关闭
+
+	//env key
+	ExpandKey       = "_expand_"
+	ParentRowkeyKey = "_prk_" //嵌套路由中父记录的id
+	ParentKey       = "_parent_"
+	IfMatchKey      = "_ifmatch_"
+	rcHolderKey     = "_rch_" //当前请求的RESTContext
+)
+
+/* }}} */
+
 /* {{{ variables
  */
 var (
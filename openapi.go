@@ -0,0 +1,508 @@
+/* OpenAPI 3文档, 路径来自路由, schema来自model的json/db/filter tag
+ * 配置 OpenAPI = true 时开启 GET /@openapi.json
+ */
+package ogo
+
+import (
+	"encoding"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Odinman/ogo/utils"
+)
+
+const (
+	OPENAPI_PATH = "/@openapi.json"
+)
+
+var (
+	oaParamRegex = regexp.MustCompile(`:([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// 文档生成器, 收集用到的schema
+type openAPI struct {
+	schemas map[string]interface{}
+}
+
+/* {{{ func OpenAPI(mux *Mux, listeners ...string) map[string]interface{}
+ * 根据mux中注册的路由生成OpenAPI 3文档, 指定listener则只包括暴露在其上的路由
+ */
+func OpenAPI(mux *Mux, listeners ...string) map[string]interface{} {
+	oa := &openAPI{schemas: make(map[string]interface{})}
+	oa.schemas["RESTError"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"message": map[string]interface{}{"type": "string"},
+			"errors": map[string]interface{}{
+				"type":                 "object",
+				"description":          "method/path/code以及字段错误(key为字段名)",
+				"additionalProperties": map[string]interface{}{"type": "string"},
+			},
+		},
+	}
+
+	keys := make([]string, 0, len(mux.Routes))
+	for k := range mux.Routes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	paths := make(map[string]interface{})
+	for _, k := range keys {
+		rt := mux.Routes[k]
+		p, ok := rt.Pattern.(string)
+		if !ok || p == "" { //正则路由无法描述
+			continue
+		}
+		if !rt.exposed(listeners...) {
+			continue
+		}
+		path, params := oaPath(p)
+		item, _ := paths[path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(rt.Method)] = oa.operation(rt, p, params)
+	}
+
+	title := env.ProcName
+	if title == "" {
+		title = "ogo"
+	}
+	version := "1.0.0"
+	if cfg := Config(); cfg != nil {
+		if v := cfg.String("Version"); v != "" {
+			version = v
+		}
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": title, "version": version},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": oa.schemas,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "error",
+					"content":     oaJSON(oaRef("RESTError")),
+				},
+			},
+		},
+	}
+}
+
+/* }}} */
+
+/* {{{ func (rtr *Router) OpenAPIDoc(c *RESTContext)
+ * GET /@openapi.json, 配置中OpenAPI为true才开启
+ */
+func (rtr *Router) OpenAPIDoc(c *RESTContext) {
+	if !env.OpenAPI {
+		c.HTTPError(http.StatusNotFound)
+		return
+	}
+	listener, _ := c.GetEnv(ListenerKey).(string)
+	c.RESTOK(OpenAPI(DMux, listener))
+}
+
+/* }}} */
+
+/* {{{ func oaPath(p string) (string, []string)
+ * goji路由转换为OpenAPI路径: /ep/:_rk_ => /ep/{id}
+ */
+func oaPath(p string) (string, []string) {
+	params := make([]string, 0)
+	path := oaParamRegex.ReplaceAllStringFunc(p, func(s string) string {
+		name := s[1:]
+		switch name {
+		case RowkeyKey:
+			name = "id"
+		case ParentRowkeyKey:
+			name = "parent_id"
+		}
+		params = append(params, name)
+		return "{" + name + "}"
+	})
+	return path, params
+}
+
+/* }}} */
+
+/* {{{ func (oa *openAPI) operation(rt *Route, p string, params []string) map[string]interface{}
+ * 一个路由的描述, 通用路由(有model)根据方法生成请求以及返回
+ */
+func (oa *openAPI) operation(rt *Route, p string, params []string) map[string]interface{} {
+	op := map[string]interface{}{}
+	ps := make([]interface{}, 0)
+	for _, name := range params {
+		ps = append(ps, map[string]interface{}{
+			"name": name, "in": "path", "required": true,
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	responses := map[string]interface{}{
+		"default": map[string]interface{}{"$ref": "#/components/responses/Error"},
+	}
+	method := strings.ToUpper(rt.Method)
+	status := strconv.Itoa(getCode(true, method))
+	m, _ := rt.Options.Get(KEY_MODEL).(Model)
+	if m == nil {
+		responses[status] = map[string]interface{}{"description": http.StatusText(getCode(true, method))}
+		op["parameters"] = ps
+		op["responses"] = responses
+		return op
+	}
+
+	name := oa.schema(reflect.TypeOf(m))
+	op["tags"] = []string{rt.Endpoint}
+	item := strings.Contains(p, ":"+RowkeyKey)
+	ok := func(desc string, schema interface{}) {
+		r := map[string]interface{}{"description": desc}
+		if schema != nil {
+			r["content"] = oaJSON(schema)
+		}
+		responses[status] = r
+	}
+	switch {
+	case strings.HasSuffix(p, "/"+_BATCH_PATH):
+		op["summary"] = "batch " + name
+		op["requestBody"] = map[string]interface{}{"required": true, "content": oaJSON(map[string]interface{}{
+			"type": "array", "items": oaBatchOp(),
+		})}
+		status = strconv.Itoa(http.StatusMultiStatus)
+		ok("multi-status", map[string]interface{}{"type": "array", "items": oaBatchResult()})
+	case method == "GET" && item:
+		op["summary"] = "get " + name
+		ps = append(ps, oa.readParams(m, false)...)
+		ok("ok", oaRef(name))
+	case method == "GET":
+		op["summary"] = "search " + name
+		ps = append(ps, oa.readParams(m, true)...)
+		ok("ok", oaList(name))
+	case method == "HEAD":
+		op["summary"] = "check " + name
+		ps = append(ps, oa.conditionParams(m)...)
+		ok("not exists", nil)
+		responses[strconv.Itoa(http.StatusConflict)] = map[string]interface{}{"description": "exists"}
+	case method == "POST":
+		op["summary"] = "create " + name
+		op["requestBody"] = map[string]interface{}{"required": true, "content": oaJSON(oaRef(name))}
+		ok("created", oaRef(name))
+	case method == "PATCH":
+		op["summary"] = "update " + name
+		content := oaJSON(oaRef(name))
+		content[MIME_MERGE_PATCH] = map[string]interface{}{"schema": oaRef(name)}
+		content[MIME_JSON_PATCH] = map[string]interface{}{"schema": map[string]interface{}{"type": "array", "items": oaPatchOp()}}
+		op["requestBody"] = map[string]interface{}{"required": true, "content": content}
+		ok("updated", oaRef(name))
+		if _, ok := versionColumn(m); ok {
+			ps = append(ps, map[string]interface{}{"name": "If-Match", "in": "header", "schema": map[string]interface{}{"type": "string"}})
+			responses[strconv.Itoa(http.StatusPreconditionFailed)] = map[string]interface{}{"$ref": "#/components/responses/Error"}
+		}
+	case method == "PUT":
+		op["summary"] = "replace " + name
+		op["requestBody"] = map[string]interface{}{"required": true, "content": oaJSON(oaRef(name))}
+		ok("replaced", oaRef(name))
+		if rt.Options.Get(KEY_UPSERT) == true {
+			responses[strconv.Itoa(http.StatusCreated)] = map[string]interface{}{"description": "created", "content": oaJSON(oaRef(name))}
+		}
+		if _, ok := versionColumn(m); ok {
+			ps = append(ps, map[string]interface{}{"name": "If-Match", "in": "header", "schema": map[string]interface{}{"type": "string"}})
+			responses[strconv.Itoa(http.StatusPreconditionFailed)] = map[string]interface{}{"$ref": "#/components/responses/Error"}
+		}
+	case method == "DELETE":
+		op["summary"] = "delete " + name
+		ok("deleted", nil)
+	default:
+		ok(http.StatusText(getCode(true, method)), nil)
+	}
+	op["parameters"] = ps
+	op["responses"] = responses
+	return op
+}
+
+/* }}} */
+
+/* {{{ func (oa *openAPI) conditionParams(m Model) []interface{}
+ * 条件参数(C以及主键), 支持前缀: !不等于, ~包含, >大于等于, <小于; 逗号分隔多值
+ */
+func (oa *openAPI) conditionParams(m Model) []interface{} {
+	ps := make([]interface{}, 0)
+	for _, col := range utils.ReadStructColumns(m, true) {
+		if col.Tag == "-" || !(col.TagOptions.Contains(DBTAG_PK) || col.ExtOptions.Contains(TAG_CONDITION)) {
+			continue
+		}
+		ps = append(ps, map[string]interface{}{
+			"name": col.Tag, "in": "query",
+			"description": "前缀: !" + col.Tag + "(不等于), ~" + col.Tag + "(包含), >" + col.Tag + "(大于等于), <" + col.Tag + "(小于); 逗号分隔多值",
+			"schema":      oa.typeSchema(col.Type),
+		})
+	}
+	return ps
+}
+
+/* }}} */
+
+/* {{{ func (oa *openAPI) readParams(m Model, list bool) []interface{}
+ * 读取参数, list为搜索
+ */
+func (oa *openAPI) readParams(m Model, list bool) []interface{} {
+	str := map[string]interface{}{"type": "string"}
+	param := func(name, desc string, schema interface{}) map[string]interface{} {
+		return map[string]interface{}{"name": name, "in": "query", "description": desc, "schema": schema}
+	}
+	ps := []interface{}{param(_PARAM_FIELDS, "返回字段, 逗号分隔", str)}
+	if rs := Relations(m); len(rs) > 0 {
+		names := make([]string, 0, len(rs))
+		for n := range rs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		ps = append(ps, param(_PARAM_EXPAND, "展开关联, 逗号分隔: "+strings.Join(names, ","), str))
+	}
+	if !list {
+		return ps
+	}
+	ps = append(ps, oa.conditionParams(m)...)
+	sorts := make([]string, 0)
+	tr := false
+	for _, col := range utils.ReadStructColumns(m, true) {
+		if col.TagOptions.Contains(DBTAG_PK) || col.ExtOptions.Contains(TAG_ORDERBY) || col.ExtOptions.Contains(TAG_AORDERBY) {
+			sorts = append(sorts, col.Tag)
+		}
+		if col.ExtOptions.Contains(TAG_TIMERANGE) {
+			tr = true
+		}
+	}
+	ps = append(ps,
+		param(_PARAM_FILTER, "过滤表达式, 如: (a = 1 OR b ~ x) AND NOT c > 2", str),
+		param(_PARAM_ORDERBY, "排序, 逗号分隔, -为降序: "+strings.Join(sorts, ","), str),
+		param(_PARAM_PAGE, "页码", map[string]interface{}{"type": "integer", "minimum": 1}),
+		param(_PARAM_PERPAGE, "每页数量", map[string]interface{}{"type": "integer", "minimum": 1}),
+		param(_PARAM_CURSOR, "游标分页, 第一页传空, 之后传返回的next_cursor", str),
+		param(_PARAM_TOTAL, "游标分页时是否返回总数", map[string]interface{}{"type": "boolean"}),
+	)
+	if tr {
+		ps = append(ps,
+			param(_PARAM_DATE, "日期范围", str),
+			param(_PARAM_START, "开始时间", str),
+			param(_PARAM_END, "结束时间", str),
+		)
+	}
+	return ps
+}
+
+/* }}} */
+
+/* {{{ func (oa *openAPI) schema(t reflect.Type) string
+ * 生成model的schema, 返回名称
+ * G为只读, S为只写, H不出现, R为必填
+ */
+func (oa *openAPI) schema(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name := t.Name()
+	if _, ok := oa.schemas[name]; ok {
+		return name
+	}
+	s := map[string]interface{}{"type": "object"}
+	oa.schemas[name] = s //先占位, 防止循环引用
+	props := make(map[string]interface{})
+	required := make([]string, 0)
+	v := reflect.New(t).Interface()
+	for _, col := range utils.ReadStructColumns(v, true) {
+		if col.ExtOptions.Contains(TAG_HIDDEN) {
+			continue
+		}
+		jn := jsonName(t, col)
+		if t.FieldByIndex(col.Index).Tag.Get("json") == "-" {
+			continue
+		}
+		ps := oa.typeSchema(col.Type)
+		if col.ExtOptions.Contains(TAG_GENERATE) || col.ExtTag == EXTTAG_VERSION {
+			ps["readOnly"] = true
+		}
+		if col.ExtOptions.Contains(TAG_SECRET) {
+			ps["writeOnly"] = true
+		}
+		oaRules(ps, col.ExtOptions)
+		props[jn] = ps
+		if col.ExtOptions.Contains(TAG_REQUIRED) {
+			required = append(required, jn)
+		}
+	}
+	for rn, r := range Relations(v) {
+		rs := oaRef(oa.schema(r.Target))
+		if r.Kind == REL_HAS_MANY {
+			rs = map[string]interface{}{"type": "array", "items": rs}
+		}
+		props[rn] = map[string]interface{}{"allOf": []interface{}{rs}, "readOnly": true, "description": "expand=" + rn}
+	}
+	s["properties"] = props
+	if len(required) > 0 {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return name
+}
+
+/* }}} */
+
+/* {{{ func (oa *openAPI) typeSchema(t reflect.Type) map[string]interface{}
+ * go类型到schema
+ */
+func (oa *openAPI) typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if reflect.PtrTo(t).Implements(reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()) {
+		return map[string]interface{}{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": oa.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": oa.typeSchema(t.Elem())}
+	case reflect.Struct:
+		return map[string]interface{}{"allOf": []interface{}{oaRef(oa.schema(t))}}
+	}
+	return map[string]interface{}{}
+}
+
+/* }}} */
+
+/* {{{ func oaRules(s map[string]interface{}, o utils.TagOptions)
+ * 校验规则写入schema
+ */
+func oaRules(s map[string]interface{}, o utils.TagOptions) {
+	isStr := s["type"] == "string"
+	for _, r := range parseRules(o) {
+		n, _ := strconv.ParseFloat(r.Arg, 64)
+		switch r.Name {
+		case RULE_MIN:
+			if isStr {
+				s["minLength"] = int(n)
+			} else {
+				s["minimum"] = n
+			}
+		case RULE_MAX:
+			if isStr {
+				s["maxLength"] = int(n)
+			} else {
+				s["maximum"] = n
+			}
+		case RULE_LEN:
+			switch r.Op {
+			case "<=":
+				s["maxLength"] = int(n)
+			case "<":
+				s["maxLength"] = int(n) - 1
+			case ">=":
+				s["minLength"] = int(n)
+			case ">":
+				s["minLength"] = int(n) + 1
+			default:
+				s["minLength"], s["maxLength"] = int(n), int(n)
+			}
+		case RULE_ENUM:
+			s["enum"] = strings.Split(r.Arg, "|")
+		case RULE_EMAIL:
+			s["format"] = "email"
+		case RULE_REGEX:
+			s["pattern"] = r.Arg
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ schema helpers
+ *
+ */
+func oaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func oaJSON(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func oaPatchOp() map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []string{"op", "path"},
+		"properties": map[string]interface{}{
+			"op":    map[string]interface{}{"type": "string", "enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+			"path":  map[string]interface{}{"type": "string"},
+			"from":  map[string]interface{}{"type": "string"},
+			"value": map[string]interface{}{},
+		},
+	}
+}
+
+func oaList(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"info": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"page":        map[string]interface{}{"type": "integer"},
+					"per_page":    map[string]interface{}{"type": "integer"},
+					"sum":         map[string]interface{}{},
+					"next_cursor": map[string]interface{}{"type": "string"},
+				},
+			},
+			"total": map[string]interface{}{"type": "integer", "description": "游标分页且没有total=true时为-1"},
+			"list":  map[string]interface{}{"type": "array", "items": oaRef(name)},
+		},
+	}
+}
+
+func oaBatchOp() map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []string{"op"},
+		"properties": map[string]interface{}{
+			"op":       map[string]interface{}{"type": "string", "enum": []string{BATCH_CREATE, BATCH_UPDATE, BATCH_DELETE}},
+			"id":       map[string]interface{}{"type": "string"},
+			"if_match": map[string]interface{}{"type": "string"},
+			"body":     map[string]interface{}{"type": "object"},
+		},
+	}
+}
+
+func oaBatchResult() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"status": map[string]interface{}{"type": "integer"},
+			"etag":   map[string]interface{}{"type": "string"},
+			"body":   map[string]interface{}{},
+		},
+	}
+}
+
+/* }}} */
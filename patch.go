@@ -0,0 +1,486 @@
+/* PATCH的补丁格式, 根据Content-Type选择
+ * application/merge-patch+json: RFC 7396, null表示删除(置空)
+ * application/json-patch+json: RFC 6902, add/remove/replace/move/copy/test
+ * 补丁作用于旧记录(GetOlder), 只更新有变化的字段
+ */
+package ogo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Odinman/ogo/utils"
+)
+
+const (
+	MIME_MERGE_PATCH = "application/merge-patch+json"
+	MIME_JSON_PATCH  = "application/json-patch+json"
+)
+
+var (
+	ErrPatchTest = errors.New("json patch test failed")
+)
+
+// json patch的一个操作
+type PatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+/* {{{ func patchType(c *RESTContext) string
+ * 请求的补丁格式, 不是补丁返回空
+ */
+func patchType(c *RESTContext) string {
+	ct, _ := c.GetEnv(MimeTypeKey).(string)
+	if ct == "" {
+		return ""
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return ""
+	}
+	switch mt = strings.ToLower(mt); mt {
+	case MIME_MERGE_PATCH, MIME_JSON_PATCH:
+		return mt
+	}
+	return ""
+}
+
+/* }}} */
+
+/* {{{ func decodeJSON(b []byte) (interface{}, error)
+ * 数字保持原样(json.Number), 避免大整数丢失精度
+ */
+func decodeJSON(b []byte) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+/* }}} */
+
+/* {{{ func MergePatch(doc, patch interface{}) interface{}
+ * RFC 7396
+ */
+func MergePatch(doc, patch interface{}) interface{} {
+	pm, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	dm, ok := doc.(map[string]interface{})
+	if !ok {
+		dm = make(map[string]interface{})
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(dm, k)
+		} else {
+			dm[k] = MergePatch(dm[k], v)
+		}
+	}
+	return dm
+}
+
+/* }}} */
+
+/* {{{ func JSONPatch(doc interface{}, ops []*PatchOp) (interface{}, error)
+ * RFC 6902, 任何一个操作失败则整体失败
+ */
+func JSONPatch(doc interface{}, ops []*PatchOp) (interface{}, error) {
+	var err error
+	for n, op := range ops {
+		var value interface{}
+		switch op.Op {
+		case "add", "replace", "test":
+			if len(op.Value) == 0 {
+				return nil, fmt.Errorf("patch[%d]: missing value", n)
+			}
+			if value, err = decodeJSON(op.Value); err != nil {
+				return nil, fmt.Errorf("patch[%d]: %s", n, err)
+			}
+		}
+		switch op.Op {
+		case "add":
+			doc, err = pointerAdd(doc, op.Path, value)
+		case "remove":
+			doc, _, err = pointerRemove(doc, op.Path)
+		case "replace":
+			if doc, _, err = pointerRemove(doc, op.Path); err == nil {
+				doc, err = pointerAdd(doc, op.Path, value)
+			}
+		case "move":
+			if op.From != op.Path && strings.HasPrefix(op.Path, op.From+"/") {
+				err = fmt.Errorf("can't move into its own child")
+				break
+			}
+			var v interface{}
+			if doc, v, err = pointerRemove(doc, op.From); err == nil {
+				doc, err = pointerAdd(doc, op.Path, v)
+			}
+		case "copy":
+			var v interface{}
+			if v, err = pointerGet(doc, op.From); err == nil {
+				doc, err = pointerAdd(doc, op.Path, deepCopy(v))
+			}
+		case "test":
+			var v interface{}
+			if v, err = pointerGet(doc, op.Path); err == nil && !jsonEqual(v, value) {
+				err = ErrPatchTest
+			}
+		default:
+			err = fmt.Errorf("unknown op: %s", op.Op)
+		}
+		if err == ErrPatchTest {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("patch[%d]: %s", n, err)
+		}
+	}
+	return doc, nil
+}
+
+/* }}} */
+
+/* {{{ func parsePointer(p string) ([]string, error)
+ * json pointer(RFC 6901), ""为整个文档
+ */
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("invalid pointer: %s", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+/* }}} */
+
+/* {{{ func arrayIndex(t string, l int, add bool) (int, error)
+ * 数组下标, add时可以是"-"(末尾)或者等于长度
+ */
+func arrayIndex(t string, l int, add bool) (int, error) {
+	if add && t == "-" {
+		return l, nil
+	}
+	i, err := strconv.Atoi(t)
+	if err != nil || i < 0 || (t != "0" && t[0] == '0') {
+		return 0, fmt.Errorf("invalid index: %s", t)
+	}
+	if i > l || (!add && i == l) {
+		return 0, fmt.Errorf("index out of range: %s", t)
+	}
+	return i, nil
+}
+
+/* }}} */
+
+/* {{{ func pointerGet(doc interface{}, p string) (interface{}, error)
+ *
+ */
+func pointerGet(doc interface{}, p string) (interface{}, error) {
+	tokens, err := parsePointer(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tokens {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			v, ok := d[t]
+			if !ok {
+				return nil, fmt.Errorf("path not found: %s", p)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(d), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = d[i]
+		default:
+			return nil, fmt.Errorf("path not found: %s", p)
+		}
+	}
+	return doc, nil
+}
+
+/* }}} */
+
+/* {{{ func pointerAdd(doc interface{}, p string, value interface{}) (interface{}, error)
+ * 返回新文档(数组插入会生成新的slice)
+ */
+func pointerAdd(doc interface{}, p string, value interface{}) (interface{}, error) {
+	tokens, err := parsePointer(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, p[:strings.LastIndex(p, "/")])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch d := parent.(type) {
+	case map[string]interface{}:
+		d[last] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(d), true)
+		if err != nil {
+			return nil, err
+		}
+		nd := make([]interface{}, 0, len(d)+1)
+		nd = append(append(append(nd, d[:i]...), value), d[i:]...)
+		return setParent(doc, tokens[:len(tokens)-1], nd), nil
+	}
+	return nil, fmt.Errorf("path not found: %s", p)
+}
+
+/* }}} */
+
+/* {{{ func pointerRemove(doc interface{}, p string) (interface{}, interface{}, error)
+ * 返回新文档以及被删除的值
+ */
+func pointerRemove(doc interface{}, p string) (interface{}, interface{}, error) {
+	tokens, err := parsePointer(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+	parent, err := pointerGet(doc, p[:strings.LastIndex(p, "/")])
+	if err != nil {
+		return nil, nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch d := parent.(type) {
+	case map[string]interface{}:
+		v, ok := d[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("path not found: %s", p)
+		}
+		delete(d, last)
+		return doc, v, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(d), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		v := d[i]
+		nd := make([]interface{}, 0, len(d)-1)
+		nd = append(append(nd, d[:i]...), d[i+1:]...)
+		return setParent(doc, tokens[:len(tokens)-1], nd), v, nil
+	}
+	return nil, nil, fmt.Errorf("path not found: %s", p)
+}
+
+/* }}} */
+
+/* {{{ func setParent(doc interface{}, tokens []string, v interface{}) interface{}
+ * 把修改后的数组放回原位置(路径已经验证过)
+ */
+func setParent(doc interface{}, tokens []string, v interface{}) interface{} {
+	if len(tokens) == 0 {
+		return v
+	}
+	cur := doc
+	for _, t := range tokens[:len(tokens)-1] {
+		switch d := cur.(type) {
+		case map[string]interface{}:
+			cur = d[t]
+		case []interface{}:
+			i, _ := strconv.Atoi(t)
+			cur = d[i]
+		}
+	}
+	last := tokens[len(tokens)-1]
+	switch d := cur.(type) {
+	case map[string]interface{}:
+		d[last] = v
+	case []interface{}:
+		i, _ := strconv.Atoi(last)
+		d[i] = v
+	}
+	return doc
+}
+
+/* }}} */
+
+/* {{{ func deepCopy(v interface{}) interface{}
+ *
+ */
+func deepCopy(v interface{}) interface{} {
+	switch d := v.(type) {
+	case map[string]interface{}:
+		nm := make(map[string]interface{}, len(d))
+		for k, v := range d {
+			nm[k] = deepCopy(v)
+		}
+		return nm
+	case []interface{}:
+		ns := make([]interface{}, len(d))
+		for i, v := range d {
+			ns[i] = deepCopy(v)
+		}
+		return ns
+	}
+	return v
+}
+
+/* }}} */
+
+/* {{{ func jsonEqual(a, b interface{}) bool
+ * 数字按数值比较(1和1.0相等)
+ */
+func jsonEqual(a, b interface{}) bool {
+	if an, ok := a.(json.Number); ok {
+		bn, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, e1 := an.Float64()
+		bf, e2 := bn.Float64()
+		if e1 == nil && e2 == nil {
+			return af == bf
+		}
+		return an == bn
+	}
+	switch ad := a.(type) {
+	case map[string]interface{}:
+		bd, ok := b.(map[string]interface{})
+		if !ok || len(ad) != len(bd) {
+			return false
+		}
+		for k, v := range ad {
+			if bv, ok := bd[k]; !ok || !jsonEqual(v, bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bd, ok := b.([]interface{})
+		if !ok || len(ad) != len(bd) {
+			return false
+		}
+		for i := range ad {
+			if !jsonEqual(ad[i], bd[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) applyPatch(pt string, patch []byte) ([]byte, error)
+ * 补丁作用于旧记录, 返回有变化的字段(被删除的为null), 变化的数据库字段记录在bm.patched
+ */
+func (bm *BaseModel) applyPatch(pt string, patch []byte) ([]byte, error) {
+	m := bm.GetModel()
+	older := m.GetOlder()
+	if older == nil {
+		return nil, ErrNoRecord
+	}
+	ob, err := json.Marshal(older)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := decodeJSON(ob)
+	if err != nil {
+		return nil, err
+	}
+	var nd interface{}
+	switch pt {
+	case MIME_MERGE_PATCH:
+		p, err := decodeJSON(patch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid merge patch: %s", err)
+		}
+		nd = MergePatch(deepCopy(doc), p)
+	case MIME_JSON_PATCH:
+		ops := make([]*PatchOp, 0)
+		if err := json.Unmarshal(patch, &ops); err != nil {
+			return nil, fmt.Errorf("invalid json patch: %s", err)
+		}
+		if nd, err = JSONPatch(deepCopy(doc), ops); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported patch type: %s", pt)
+	}
+	om, _ := doc.(map[string]interface{})
+	nm, ok := nd.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("patched document must be an object")
+	}
+
+	// 只保留有变化的字段
+	changed := make(map[string]interface{})
+	for k, v := range nm {
+		if ov, ok := om[k]; !ok || !jsonEqual(ov, v) {
+			changed[k] = v
+		}
+	}
+	for k := range om {
+		if _, ok := nm[k]; !ok {
+			changed[k] = nil
+		}
+	}
+	t := reflect.TypeOf(m)
+	bm.patched = make(map[string]bool)
+	for _, col := range utils.ReadStructColumns(m, true) {
+		if _, ok := changed[jsonName(t, col)]; ok && col.Tag != "-" {
+			bm.patched[col.Tag] = true
+		}
+	}
+	return json.Marshal(changed)
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) markChanged()
+ * 校验以及BeforeUpdate中设置的字段(非空并且与旧记录不同)也记入bm.patched
+ */
+func (bm *BaseModel) markChanged() {
+	m := bm.GetModel()
+	older := m.GetOlder()
+	if older == nil || reflect.TypeOf(older) != reflect.TypeOf(m) {
+		return
+	}
+	v, ov := reflect.ValueOf(m), reflect.ValueOf(older)
+	for _, col := range utils.ReadStructColumns(m, true) {
+		if col.Tag == "-" || bm.patched[col.Tag] {
+			continue
+		}
+		fv, ofv := utils.FieldByIndex(v, col.Index), utils.FieldByIndex(ov, col.Index)
+		if !fv.IsValid() || !fv.CanInterface() || utils.IsEmptyValue(fv) {
+			continue
+		}
+		if !ofv.IsValid() || !ofv.CanInterface() || !reflect.DeepEqual(fv.Interface(), ofv.Interface()) {
+			bm.patched[col.Tag] = true
+		}
+	}
+}
+
+/* }}} */
@@ -0,0 +1,147 @@
+package ogo
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func mustJSON(t *testing.T, s string) interface{} {
+	v, err := decodeJSON([]byte(s))
+	if err != nil {
+		t.Fatalf("decode %s: %s", s, err)
+	}
+	return v
+}
+
+// RFC 7396 Appendix A
+func TestMergePatch(t *testing.T) {
+	cases := []struct{ doc, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, c := range cases {
+		got := MergePatch(mustJSON(t, c.doc), mustJSON(t, c.patch))
+		if !jsonEqual(got, mustJSON(t, c.want)) {
+			b, _ := json.Marshal(got)
+			t.Errorf("MergePatch(%s, %s) = %s, want %s", c.doc, c.patch, b, c.want)
+		}
+	}
+}
+
+// RFC 6902 Appendix A, want为空表示应该失败
+func TestJSONPatch(t *testing.T) {
+	cases := []struct{ doc, patch, want string }{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ``},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ``},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, ``},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		// 失败时整体不生效
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":1},{"op":"remove","path":"/nope"}]`, ``},
+		{`{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar"}]`, ``},
+		{`{"foo":"bar"}`, `[{"op":"copy","from":"/foo","path":"/baz"}]`, `{"foo":"bar","baz":"bar"}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`, ``},
+		{`{"foo":"bar"}`, `[{"op":"unknown","path":"/baz"}]`, ``},
+	}
+	for _, c := range cases {
+		ops := make([]*PatchOp, 0)
+		if err := json.Unmarshal([]byte(c.patch), &ops); err != nil {
+			t.Fatalf("patch %s: %s", c.patch, err)
+		}
+		got, err := JSONPatch(mustJSON(t, c.doc), ops)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("JSONPatch(%s, %s) should fail", c.doc, c.patch)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("JSONPatch(%s, %s): %s", c.doc, c.patch, err)
+		} else if !jsonEqual(got, mustJSON(t, c.want)) {
+			b, _ := json.Marshal(got)
+			t.Errorf("JSONPatch(%s, %s) = %s, want %s", c.doc, c.patch, b, c.want)
+		}
+	}
+}
+
+// RFC 6901 Section 5
+func TestPointerGet(t *testing.T) {
+	doc := mustJSON(t, `{"foo":["bar","baz"],"":0,"a/b":1,"c%d":2,"e^f":3,"g|h":4,"i\\j":5,"k\"l":6," ":7,"m~n":8}`)
+	cases := []struct{ p, want string }{
+		{``, `{"foo":["bar","baz"],"":0,"a/b":1,"c%d":2,"e^f":3,"g|h":4,"i\\j":5,"k\"l":6," ":7,"m~n":8}`},
+		{`/foo`, `["bar","baz"]`},
+		{`/foo/0`, `"bar"`},
+		{`/`, `0`},
+		{`/a~1b`, `1`},
+		{`/c%d`, `2`},
+		{`/e^f`, `3`},
+		{`/g|h`, `4`},
+		{`/i\j`, `5`},
+		{`/k"l`, `6`},
+		{`/ `, `7`},
+		{`/m~0n`, `8`},
+	}
+	for _, c := range cases {
+		v, err := pointerGet(doc, c.p)
+		if err != nil {
+			t.Errorf("pointerGet(%q): %s", c.p, err)
+		} else if !jsonEqual(v, mustJSON(t, c.want)) {
+			t.Errorf("pointerGet(%q) = %v, want %s", c.p, v, c.want)
+		}
+	}
+	for _, p := range []string{"foo", "/bar", "/foo/2", "/foo/01", "/foo/-", "/foo/x", "/foo/0/a"} {
+		if _, err := pointerGet(doc, p); err == nil {
+			t.Errorf("pointerGet(%q) should fail", p)
+		}
+	}
+}
+
+func TestPointerAddRemove(t *testing.T) {
+	doc := mustJSON(t, `{"a":{"b":[1,2]}}`)
+	doc, err := pointerAdd(doc, "/a/b/0", mustJSON(t, `0`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, v, err := pointerRemove(doc, "/a/b/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !jsonEqual(v, mustJSON(t, `2`)) || !jsonEqual(doc, mustJSON(t, `{"a":{"b":[0,1]}}`)) {
+		t.Errorf("got %v, removed %v", doc, v)
+	}
+	if _, err := pointerAdd(doc, "/a/b/3", mustJSON(t, `3`)); err == nil {
+		t.Errorf("add out of range should fail")
+	}
+	if _, _, err := pointerRemove(doc, "/a/c"); err == nil {
+		t.Errorf("remove missing key should fail")
+	}
+	// 替换整个文档
+	if root, err := pointerAdd(doc, "", mustJSON(t, `[1]`)); err != nil || !jsonEqual(root, mustJSON(t, `[1]`)) {
+		t.Errorf("add root: %v, %v", root, err)
+	}
+}
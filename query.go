@@ -0,0 +1,202 @@
+/* 查询, 按方言生成SELECT语句, 表别名为T, 由执行器(数据库连接或者事务)执行 */
+package ogo
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/Odinman/gorp"
+)
+
+// 查询构造
+type Query struct {
+	db     gorp.SqlExecutor
+	d      Dialect
+	table  string
+	fields string
+	joins  []string
+	wheres []string
+	args   []interface{}
+	groups []string
+	orders []string
+	limit  int
+	offset int
+}
+
+/* {{{ func NewQuery(db gorp.SqlExecutor, d Dialect) *Query
+ *
+ */
+func NewQuery(db gorp.SqlExecutor, d Dialect) *Query {
+	return &Query{db: db, d: d, fields: "*"}
+}
+
+/* }}} */
+
+// 生成语句用的方言
+func (q *Query) Dialect() Dialect {
+	return q.d
+}
+
+func (q *Query) Table(t string) *Query {
+	q.table = t
+	return q
+}
+
+func (q *Query) Where(w string, args ...interface{}) *Query {
+	q.wheres = append(q.wheres, w)
+	q.args = append(q.args, args...)
+	return q
+}
+
+func (q *Query) Joins(j string) *Query {
+	q.joins = append(q.joins, j)
+	return q
+}
+
+func (q *Query) Order(o string) *Query {
+	q.orders = append(q.orders, o)
+	return q
+}
+
+// 分组字段, 覆盖之前的
+func (q *Query) Group(g []string) *Query {
+	q.groups = q.columns(g)
+	return q
+}
+
+func (q *Query) Limit(l int) *Query {
+	q.limit = l
+	return q
+}
+
+func (q *Query) Offset(o int) *Query {
+	q.offset = o
+	return q
+}
+
+/* {{{ func (q *Query) Select(f interface{}) *Query
+ * []string为字段名, string为原样的表达式
+ */
+func (q *Query) Select(f interface{}) *Query {
+	switch ft := f.(type) {
+	case []string:
+		if len(ft) > 0 {
+			q.fields = strings.Join(q.columns(ft), ",")
+		}
+	case string:
+		if ft != "" {
+			q.fields = ft
+		}
+	}
+	return q
+}
+
+/* }}} */
+
+/* {{{ func (q *Query) columns(fs []string) []string
+ * 字段加上表别名
+ */
+func (q *Query) columns(fs []string) []string {
+	cs := make([]string, len(fs))
+	for i, f := range fs {
+		cs[i] = "T." + q.d.Quote(f)
+	}
+	return cs
+}
+
+/* }}} */
+
+/* {{{ func (q *Query) from() string
+ * FROM ... JOIN ... WHERE ..., 每个条件加括号
+ */
+func (q *Query) from() string {
+	var buf bytes.Buffer
+	buf.WriteString(" FROM " + q.d.Quote(q.table) + " AS T")
+	for _, j := range q.joins {
+		buf.WriteString(" " + j)
+	}
+	for i, w := range q.wheres {
+		if i == 0 {
+			buf.WriteString(" WHERE ")
+		} else {
+			buf.WriteString(" AND ")
+		}
+		buf.WriteString("(" + w + ")")
+	}
+	return buf.String()
+}
+
+/* }}} */
+
+/* {{{ func (q *Query) SQL() (string, []interface{})
+ * 生成的语句(占位符为"?")以及参数
+ */
+func (q *Query) SQL() (string, []interface{}) {
+	s := "SELECT " + q.fields + q.from()
+	if len(q.groups) > 0 {
+		s += " GROUP BY " + strings.Join(q.groups, ",")
+	}
+	if len(q.orders) > 0 && len(q.groups) == 0 { //分组(报表)时排序字段不一定在结果中
+		s += " ORDER BY " + strings.Join(q.orders, ",")
+	}
+	if q.limit > 0 {
+		s += q.d.Limit(q.limit, q.offset)
+	}
+	return s, q.args
+}
+
+/* }}} */
+
+/* {{{ func (q *Query) Find(i interface{}) error
+ * i为slice指针
+ */
+func (q *Query) Find(i interface{}) error {
+	s, args := q.SQL()
+	_, err := q.db.Select(i, Rebind(q.d, s), args...)
+	return err
+}
+
+/* }}} */
+
+/* {{{ func (q *Query) Count() (int64, error)
+ * 符合条件的记录数, 有分组时为分组数
+ */
+func (q *Query) Count() (int64, error) {
+	s := "SELECT COUNT(*)" + q.from()
+	if len(q.groups) > 0 {
+		s = "SELECT COUNT(*) FROM (SELECT 1" + q.from() + " GROUP BY " + strings.Join(q.groups, ",") + ") AS C"
+	}
+	return q.db.SelectInt(Rebind(q.d, s), q.args...)
+}
+
+/* }}} */
+
+/* {{{ func (q *Query) InsertSQL(cols []string, pk string) string
+ * 单行INSERT, pk为自增主键时按方言加上RETURNING
+ */
+func (q *Query) InsertSQL(cols []string, pk string) string {
+	s := upsertValues(q.d, q.table, cols, 1)
+	if pk != "" {
+		s += q.d.Returning(pk)
+	}
+	return s
+}
+
+/* }}} */
+
+/* {{{ func (q *Query) Insert(cols []string, vals []interface{}, pk string) (int64, error)
+ * 插入一行, pk为自增主键时返回生成的id
+ */
+func (q *Query) Insert(cols []string, vals []interface{}, pk string) (int64, error) {
+	s := Rebind(q.d, q.InsertSQL(cols, pk))
+	if pk != "" && q.d.Returning(pk) != "" {
+		return q.db.SelectInt(s, vals...)
+	}
+	r, err := q.db.Exec(s, vals...)
+	if err != nil || pk == "" {
+		return 0, err
+	}
+	return r.LastInsertId()
+}
+
+/* }}} */
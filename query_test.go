@@ -0,0 +1,50 @@
+package ogo
+
+import (
+	"testing"
+)
+
+func TestQuerySQL(t *testing.T) {
+	cases := []struct {
+		d    Dialect
+		q    func(q *Query) *Query
+		want string
+	}{
+		{MySQL, func(q *Query) *Query {
+			return q.Select([]string{"id", "name"}).Where("T.`a` = ?", 1).Where("T.`b` = ? OR T.`c` = ?", 2, 3).Order("T.`id` DESC").Offset(20).Limit(10)
+		}, "SELECT T.`id`,T.`name` FROM `t` AS T WHERE (T.`a` = ?) AND (T.`b` = ? OR T.`c` = ?) ORDER BY T.`id` DESC LIMIT 20, 10"},
+		{Postgres, func(q *Query) *Query {
+			return q.Select([]string{"id"}).Order(`T."id" DESC`).Offset(20).Limit(10)
+		}, `SELECT T."id" FROM "t" AS T ORDER BY T."id" DESC LIMIT 10 OFFSET 20`},
+		{SQLite, func(q *Query) *Query {
+			return q.Select([]string{"id"}).Limit(1)
+		}, `SELECT T."id" FROM "t" AS T LIMIT 1`},
+		{MySQL, func(q *Query) *Query {
+			return q.Select("T.`g`,SUM(T.`n`) AS `n`").Group([]string{"g"}).Order("T.`id` DESC")
+		}, "SELECT T.`g`,SUM(T.`n`) AS `n` FROM `t` AS T GROUP BY T.`g`"},
+	}
+	for _, c := range cases {
+		if sql, _ := c.q(NewQuery(nil, c.d).Table("t")).SQL(); sql != c.want {
+			t.Errorf("%s: %s\nwant: %s", c.d.Name(), sql, c.want)
+		}
+	}
+}
+
+func TestQueryInsertSQL(t *testing.T) {
+	cases := []struct {
+		d    Dialect
+		pk   string
+		want string
+	}{
+		{Postgres, "id", `INSERT INTO "t" ("a","b") VALUES ($1,$2) RETURNING "id"`},
+		{Postgres, "", `INSERT INTO "t" ("a","b") VALUES ($1,$2)`},
+		{MySQL, "id", "INSERT INTO `t` (`a`,`b`) VALUES (?,?)"},
+		{SQLite, "id", `INSERT INTO "t" ("a","b") VALUES (?,?)`},
+	}
+	for _, c := range cases {
+		q := NewQuery(nil, c.d).Table("t")
+		if sql := Rebind(c.d, q.InsertSQL([]string{"a", "b"}, c.pk)); sql != c.want {
+			t.Errorf("%s: %s\nwant: %s", c.d.Name(), sql, c.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+/* 关联资源, 通过expand参数展开
+ * belongs_to: `rel:"belongs_to,fk=creator_id,tk=id"`, fk为本表字段, tk为目标表字段(默认目标主键)
+ * has_many:   `rel:"has_many,fk=order_id,tk=id"`, fk为目标表字段, tk为本表字段(默认本表主键)
+ * 关联字段本身不是数据库字段, 需要 db:"-"; 名称为json名
+ */
+package ogo
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Odinman/ogo/utils"
+)
+
+const (
+	REL_TAG        = "rel"
+	REL_BELONGS_TO = "belongs_to"
+	REL_HAS_MANY   = "has_many"
+)
+
+var (
+	relationCache = utils.NewSafeMap() // reflect.Type => map[string]*Relation
+)
+
+// 关联定义
+type Relation struct {
+	Name   string       // 对外名称(json)
+	Kind   string       // belongs_to/has_many
+	FK     string       // 外键
+	TK     string       // 目标键
+	Index  []int        // 关联字段
+	Target reflect.Type // 目标model类型(指针)
+}
+
+/* {{{ func Relations(m interface{}) map[string]*Relation
+ * 读取model中定义的关联, 按类型缓存
+ */
+func Relations(m interface{}) map[string]*Relation {
+	t := reflect.TypeOf(m)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if rs, ok := relationCache.Get(t).(map[string]*Relation); ok {
+		return rs
+	}
+	rs := make(map[string]*Relation)
+	if t.Kind() != reflect.Struct {
+		return rs
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get(REL_TAG)
+		if tag == "" {
+			continue
+		}
+		kind, opts := utils.ParseTag(tag)
+		r := &Relation{Kind: kind, Index: f.Index}
+		for _, opt := range strings.Split(string(opts), ",") {
+			if kv := strings.SplitN(opt, "=", 2); len(kv) == 2 {
+				switch kv[0] {
+				case "fk":
+					r.FK = kv[1]
+				case "tk":
+					r.TK = kv[1]
+				}
+			}
+		}
+		// 目标类型, belongs_to为*T, has_many为[]*T
+		ft := f.Type
+		if kind == REL_HAS_MANY && ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		} else if kind != REL_BELONGS_TO {
+			Warn("invalid relation %s.%s: %s", t.Name(), f.Name, tag)
+			continue
+		}
+		if ft.Kind() != reflect.Ptr || !ft.Implements(reflect.TypeOf((*Model)(nil)).Elem()) || r.FK == "" {
+			Warn("invalid relation %s.%s: %s", t.Name(), f.Name, tag)
+			continue
+		}
+		r.Target = ft
+		if r.Name, _ = utils.ParseTag(f.Tag.Get("json")); r.Name == "" || r.Name == "-" {
+			r.Name = utils.Underscore(f.Name)
+		}
+		rs[r.Name] = r
+	}
+	relationCache.Set(t, rs)
+	return rs
+}
+
+/* }}} */
+
+/* {{{ func CheckExpand(m Model, names []string) FieldErrors
+ * expand参数中的关联必须存在
+ */
+func CheckExpand(m Model, names []string) FieldErrors {
+	rs := Relations(m)
+	for _, name := range names {
+		if _, ok := rs[name]; !ok {
+			return FieldErrors{_PARAM_EXPAND: "unknown relation: " + name}
+		}
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func keyString(v reflect.Value) (string, bool)
+ * 关联键的值, 空值返回false
+ */
+func keyString(v reflect.Value) (string, bool) {
+	for v.IsValid() && v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", false
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || utils.IsEmptyValue(v) {
+		return "", false
+	}
+	return fmt.Sprint(v.Interface()), true
+}
+
+/* }}} */
+
+/* {{{ func fieldByTag(m interface{}, tag string) ([]int, bool)
+ *
+ */
+func fieldByTag(m interface{}, tag string) ([]int, bool) {
+	for _, col := range utils.ReadStructColumns(m, true) {
+		if col.Tag == tag {
+			return col.Index, true
+		}
+	}
+	return nil, false
+}
+
+/* }}} */
+
+/* {{{ func Expand(m Model, data interface{}, names []string) error
+ * 展开关联, data为单个model或者model列表(GetRows的结果)
+ * 每个关联只查一次: SELECT ... WHERE tk IN (...)
+ */
+func Expand(m Model, data interface{}, names []string) error {
+	if len(names) == 0 || data == nil {
+		return nil
+	}
+	if fe := CheckExpand(m, names); fe != nil {
+		return fe
+	}
+	rows := make([]reflect.Value, 0)
+	switch d := data.(type) {
+	case *List:
+		return Expand(m, d.List, names)
+	case Model:
+		rows = append(rows, reflect.ValueOf(d))
+	default:
+		lv := reflect.Indirect(reflect.ValueOf(data))
+		if lv.Kind() != reflect.Slice {
+			return nil
+		}
+		for i := 0; i < lv.Len(); i++ {
+			rows = append(rows, lv.Index(i))
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	rs := Relations(m)
+	for _, name := range names {
+		if err := expandRelation(m, rows, rs[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func expandRelation(m Model, rows []reflect.Value, r *Relation) error
+ *
+ */
+func expandRelation(m Model, rows []reflect.Value, r *Relation) error {
+	target := NewModel(reflect.New(r.Target.Elem()).Interface().(Model), m.GetCtx(), modelTx(m))
+	// 本表用于匹配的字段, 目标表用于查询的字段
+	local, remote := r.FK, r.TK
+	if r.Kind == REL_HAS_MANY {
+		local, remote = r.TK, r.FK
+		if local == "" {
+			local, _, _ = m.PKey()
+		}
+	} else if remote == "" {
+		remote, _, _ = target.PKey()
+	}
+	li, ok := fieldByTag(m, local)
+	if !ok {
+		return fmt.Errorf("relation %s: not found field %s", r.Name, local)
+	}
+	ri, ok := fieldByTag(target, remote)
+	if !ok {
+		return fmt.Errorf("relation %s: not found field %s", r.Name, remote)
+	}
+
+	ids := make([]interface{}, 0, len(rows))
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		if k, ok := keyString(utils.FieldByIndex(row, li)); ok && !seen[k] {
+			seen[k] = true
+			ids = append(ids, k)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	b, err := target.ReadPrepare()
+	if err != nil {
+		return err
+	}
+	d := b.Dialect()
+	ms := target.NewList()
+	b.Where(fmt.Sprintf("T.%s IN (%s)", d.Quote(remote), placeholders(len(ids))), ids...)
+	if err := b.Select(GetDbFields(target, true)).Find(ms); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err := afterFindList(ms); err != nil {
+		return err
+	}
+
+	// 按目标键分组
+	found := make(map[string][]reflect.Value)
+	lv := reflect.Indirect(reflect.ValueOf(ms))
+	for i := 0; i < lv.Len(); i++ {
+		if k, ok := keyString(utils.FieldByIndex(lv.Index(i), ri)); ok {
+			found[k] = append(found[k], lv.Index(i))
+		}
+	}
+	for _, row := range rows {
+		k, ok := keyString(utils.FieldByIndex(row, li))
+		if !ok {
+			continue
+		}
+		fv := utils.FieldByIndex(row, r.Index)
+		if !fv.IsValid() || !fv.CanSet() {
+			continue
+		}
+		if r.Kind == REL_HAS_MANY {
+			sv := reflect.MakeSlice(fv.Type(), 0, len(found[k]))
+			sv = reflect.Append(sv, found[k]...)
+			fv.Set(sv)
+		} else if vs := found[k]; len(vs) > 0 {
+			fv.Set(vs[0])
+		}
+	}
+	return nil
+}
+
+/* }}} */
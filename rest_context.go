@@ -37,6 +37,8 @@ type RESTContext struct {
 	App           interface{}
 	tasks         []*Task
 	locks         map[string]*Lock //访问锁
+	tx            *Tx              //请求事务
+	sharedTx      bool             //事务属于外层请求(batch), 由外层结束
 }
 
 type OTPSpec struct {
@@ -65,8 +67,8 @@ func getCode(ifSuc bool, m string) (s int) {
 			return http.StatusOK
 		case "delete":
 			return http.StatusNoContent
-		case "put":
-			return http.StatusCreated
+		case "put": //替换, 创建时由OnReplace设为201
+			return http.StatusOK
 		case "post":
 			return http.StatusCreated
 		case "patch":
@@ -176,6 +178,12 @@ func (rc *RESTContext) NewRESTError(status int, msg interface{}) (re error) {
 	} else {
 		message = fmt.Sprint(msg)
 	}
+	// 字段错误, 逐个放入errors
+	if fe, ok := msg.(FieldErrors); ok {
+		for k, v := range fe {
+			errors[k] = v
+		}
+	}
 	re = &RESTError{
 		Message: message,
 		Errors:  errors,
@@ -6,9 +6,9 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/Odinman/ogo/utils"
-	"github.com/zenazn/goji"
 	"github.com/zenazn/goji/web"
 )
 
@@ -17,24 +17,31 @@ type Handler func(c *RESTContext)
 type RouteOption map[interface{}]interface{}
 
 type Route struct {
-	Key      string //独立标识
-	Endpoint string
-	Pattern  interface{}
-	Method   string
-	Handler  Handler
-	Options  *utils.SafeMap
-	Updating bool
-	Creating bool
+	Key       string //独立标识
+	Endpoint  string
+	Pattern   interface{}
+	Method    string
+	Handler   Handler
+	Options   *utils.SafeMap
+	Updating  bool
+	Creating  bool
+	Replacing bool
+
+	router *Router   // 所属router
+	once   sync.Once // 中间件只组合一次
+	chain  Handler   // 组合中间件之后的handler
 }
 
 type Router struct {
-	Endpoint   string
-	Routes     map[string]*Route
-	Hooks      map[string]TagHook
-	SRoutes    []*Route //排序的Route
-	ReqCount   int      //访问计数
-	Mux        *Mux
-	Controller interface{} //既是RouterInterface, 也是 ActionInterface
+	Endpoint    string
+	Routes      map[string]*Route
+	Hooks       map[string]TagHook
+	SRoutes     []*Route //排序的Route
+	ReqCount    int      //访问计数
+	Mux         *Mux
+	Controller  interface{}  //既是RouterInterface, 也是 ActionInterface
+	Middlewares []Middleware //作用于所有路由的中间件
+	Listeners   []string     //暴露在哪些listener上, 为空则为全部公开的listener
 }
 
 type RouterInterface interface {
@@ -77,11 +84,13 @@ func NewRoute(p interface{}, ep string, m string, h Handler, options ...RouteOpt
 		r.Options = utils.NewSafeMap()
 	}
 
-	//更新还是创建
+	//更新/替换还是创建
 	if m == "POST" {
 		r.Creating = true
 	} else if m == "PATCH" {
 		r.Updating = true
+	} else if m == "PUT" {
+		r.Replacing = true
 	}
 
 	return r
@@ -89,6 +98,24 @@ func NewRoute(p interface{}, ep string, m string, h Handler, options ...RouteOpt
 
 /* }}} */
 
+/* {{{ func mergeOptions(base RouteOption, options ...RouteOption) RouteOption
+ * 复制一份路由选项, 每个路由各自一份, options覆盖base
+ */
+func mergeOptions(base RouteOption, options ...RouteOption) RouteOption {
+	ro := RouteOption{}
+	for k, v := range base {
+		ro[k] = v
+	}
+	for _, o := range options {
+		for k, v := range o {
+			ro[k] = v
+		}
+	}
+	return ro
+}
+
+/* }}} */
+
 /* {{{ func handlerWrap(rt *Route) web.HandlerFunc
  * 封装
  */
@@ -104,28 +131,25 @@ func handlerWrap(rt *Route) web.HandlerFunc { //这里封装了webC到本地的
 			rc.SetEnv(NoLogKey, true)
 		}
 
-		// pre hooks, 任何一个出错,都要结束
-		if hl := len(DMux.Hooks.preHooks); hl > 0 {
+		if DMux.Hooks.locked {
 			DMux.Hooks.lock.Lock()
 			defer DMux.Hooks.lock.Unlock()
-			for i := 0; i < hl; i++ {
-				if err := DMux.Hooks.preHooks[i](rc); err != nil {
-					rc.RESTError(err)
-					return
-				}
+		}
+
+		// pre hooks, 任何一个出错,都要结束
+		for _, hook := range DMux.Hooks.PreHooks() {
+			if err := hook(rc); err != nil {
+				rc.RESTError(err)
+				return
 			}
 		}
 
-		// 执行业务handler
-		rt.Handler(rc)
+		// 执行业务handler(包括中间件)
+		rt.handler()(rc)
 
 		// post hooks
-		if hl := len(DMux.Hooks.postHooks); hl > 0 {
-			DMux.Hooks.lock.Lock()
-			defer DMux.Hooks.lock.Unlock()
-			for i := 0; i < hl; i++ {
-				DMux.Hooks.postHooks[i](rc)
-			}
+		for _, hook := range DMux.Hooks.PostHooks() {
+			hook(rc)
 		}
 	}
 	return fn
@@ -179,6 +203,8 @@ func (rtr *Router) Init() {
 				rtr.RoutePatch(rt)
 			case "head":
 				rtr.RouteHead(rt)
+			case "options":
+				rtr.RouteOptions(rt)
 			default:
 				// unknow method
 			}
@@ -242,6 +268,7 @@ func (rtr *Router) AddRoute(m string, p interface{}, h Handler, options ...Route
 		rtr.SRoutes = make([]*Route, 0)
 	}
 	rt := NewRoute(p, rtr.GetEndpoint(), m, h, options...)
+	rt.router = rtr
 	key := rt.Key
 	if _, ok := rtr.Routes[key]; ok {
 		//手动加路由, 如果冲突则以最早的为准
@@ -311,69 +338,94 @@ func (rtr *Router) DefaultRoutes() {
 /* }}} */
 
 /* {{{ goji's methods
- *
+ * 注册到router所在的每个listener的mux
  */
 func (rtr *Router) RouteGet(rt *Route) {
-	goji.Get(rt.Pattern, handlerWrap(rt))
+	for _, m := range rtr.muxes() {
+		m.Get(rt.Pattern, handlerWrap(rt))
+	}
 }
 
 func (rtr *Router) RoutePost(rt *Route) {
-	goji.Post(rt.Pattern, handlerWrap(rt))
+	for _, m := range rtr.muxes() {
+		m.Post(rt.Pattern, handlerWrap(rt))
+	}
 }
 
 func (rtr *Router) RoutePut(rt *Route) {
-	goji.Put(rt.Pattern, handlerWrap(rt))
+	for _, m := range rtr.muxes() {
+		m.Put(rt.Pattern, handlerWrap(rt))
+	}
 }
 
 func (rtr *Router) RouteDelete(rt *Route) {
-	goji.Delete(rt.Pattern, handlerWrap(rt))
+	for _, m := range rtr.muxes() {
+		m.Delete(rt.Pattern, handlerWrap(rt))
+	}
 }
 
 func (rtr *Router) RoutePatch(rt *Route) {
-	goji.Patch(rt.Pattern, handlerWrap(rt))
+	for _, m := range rtr.muxes() {
+		m.Patch(rt.Pattern, handlerWrap(rt))
+	}
 }
 
 func (rtr *Router) RouteHead(rt *Route) {
-	goji.Head(rt.Pattern, handlerWrap(rt))
+	for _, m := range rtr.muxes() {
+		m.Head(rt.Pattern, handlerWrap(rt))
+	}
+}
+
+func (rtr *Router) RouteOptions(rt *Route) {
+	for _, m := range rtr.muxes() {
+		m.Options(rt.Pattern, handlerWrap(rt))
+	}
 }
 
 func (rtr *Router) RouteNotFound(rt *Route) {
-	goji.NotFound(handlerWrap(rt))
+	for _, m := range rtr.muxes() {
+		m.NotFound(handlerWrap(rt))
+	}
 }
 
 /* }}} */
 
-/* {{{ func (rtr *Router) GenericRoute(i interface{}, flag int)
+/* {{{ func (rtr *Router) GenericRoute(i interface{}, flag int, options ...RouteOption)
  * 自动路由, 任何implelent了Action的类型都可以使用
+ * options作用于所有生成的路由, 比如 RouteOption{KEY_CONDITIONAL: false} 关闭条件GET
  */
-func (rtr *Router) GenericRoute(i interface{}, flag int) {
+func (rtr *Router) GenericRoute(i interface{}, flag int, options ...RouteOption) {
 	endpoint := rtr.GetEndpoint()
 	if flag&GA_HEAD > 0 {
 		// HEAD /{endpoint}
-		rtr.AddRoute("HEAD", "/"+endpoint, rtr.CRUD(i, GA_HEAD), RouteOption{KEY_SKIPLOGIN: true}) //HEAD默认无需登录
+		rtr.AddRoute("HEAD", "/"+endpoint, rtr.CRUD(i, GA_HEAD), mergeOptions(RouteOption{KEY_SKIPLOGIN: true, KEY_MODEL: i}, options...)) //HEAD默认无需登录
 	}
 	if flag&GA_GET > 0 {
 		// GET /{endpoint}
-		rtr.AddRoute("GET", "/"+endpoint, rtr.CRUD(i, GA_SEARCH))
+		rtr.AddRoute("GET", "/"+endpoint, rtr.CRUD(i, GA_SEARCH), mergeOptions(RouteOption{KEY_MODEL: i}, options...))
 		// GET /{endpoint}/{id}
-		rtr.AddRoute("GET", "/"+endpoint+"/:"+RowkeyKey, rtr.CRUD(i, GA_GET))
+		rtr.AddRoute("GET", "/"+endpoint+"/:"+RowkeyKey, rtr.CRUD(i, GA_GET), mergeOptions(RouteOption{KEY_MODEL: i}, options...))
 	}
 	if flag&GA_POST > 0 {
 		// POST /{endpoint}
-		rtr.AddRoute("POST", "/"+endpoint, rtr.CRUD(i, GA_POST))
+		rtr.AddRoute("POST", "/"+endpoint, rtr.CRUD(i, GA_POST), mergeOptions(RouteOption{KEY_MODEL: i}, options...))
 	}
 	if flag&GA_DELETE > 0 {
 		// DELETE /{endpoint}/{id}
-		rtr.AddRoute("DELETE", "/"+endpoint+"/:"+RowkeyKey, rtr.CRUD(i, GA_DELETE))
+		rtr.AddRoute("DELETE", "/"+endpoint+"/:"+RowkeyKey, rtr.CRUD(i, GA_DELETE), mergeOptions(RouteOption{KEY_MODEL: i}, options...))
 	}
 	if flag&GA_PATCH > 0 {
 		// PATCH /{endpoint}/{id}
-		rtr.AddRoute("PATCH", "/"+endpoint+"/:"+RowkeyKey, rtr.CRUD(i, GA_PATCH))
+		rtr.AddRoute("PATCH", "/"+endpoint+"/:"+RowkeyKey, rtr.CRUD(i, GA_PATCH), mergeOptions(RouteOption{KEY_MODEL: i}, options...))
+	}
+	if flag&GA_BATCH > 0 {
+		// POST /{endpoint}/@batch
+		rtr.AddRoute("POST", "/"+endpoint+"/"+_BATCH_PATH, rtr.Batch(i, flag), mergeOptions(RouteOption{KEY_MODEL: i}, options...))
+	}
+	if flag&GA_PUT > 0 {
+		// PUT /{endpoint}/{id}
+		rtr.AddRoute("PUT", "/"+endpoint+"/:"+RowkeyKey, rtr.CRUD(i, GA_PUT), mergeOptions(RouteOption{KEY_MODEL: i}, options...))
 	}
-	//if flag&GA_PUT > 0 {
-	//	// PUT /{endpoint}/{id}
-	//	rtr.AddRoute("PUT", "/"+endpoint+"/:"+RowkeyKey, CRUD(m, GA_PUT))
-	//}
 }
 
 /* }}} */
@@ -405,6 +457,16 @@ func (rtr *Router) CRUD(i interface{}, flag int) Handler {
 			}
 			return
 		}
+		// 有版本号的记录, 版本号即ETag(fields/expand不同则内容不同, 加上摘要)
+		if rm, ok := r.(Model); ok {
+			if etag := ModelETag(rm); etag != "" {
+				if im, _ := c.GetEnv(IfMatchKey).(string); im != "" && !matchVersion(im, etag) {
+					c.RESTGenericError(http.StatusPreconditionFailed, ErrPreconditionFailed)
+					return
+				}
+				c.SetHeader("ETag", c.variantETag(etag))
+			}
+		}
 
 		if r, err = act.PostGet(r); err != nil {
 			c.Warn("PostGet error: %s", err)
@@ -429,6 +491,8 @@ func (rtr *Router) CRUD(i interface{}, flag int) Handler {
 			c.Warn("OnSearch error: %s", err)
 			if err == ErrNoRecord {
 				c.RESTNotFound(err)
+			} else if err == ErrInvalidCursor {
+				c.RESTBadRequest(FieldErrors{_PARAM_CURSOR: err.Error()})
 			} else {
 				c.RESTPanic(err)
 			}
@@ -464,10 +528,11 @@ func (rtr *Router) CRUD(i interface{}, flag int) Handler {
 		}
 		m = r.(Model)
 
-		// 触发器
-		r, err = act.Trigger(m)
-		if err != nil {
+		// 触发器, 出错则整个请求回滚
+		if r, err = act.Trigger(m); err != nil {
 			c.Warn("Trigger error: %s", err)
+			c.RESTError(err)
+			return
 		}
 
 		// create ok, return
@@ -502,10 +567,11 @@ func (rtr *Router) CRUD(i interface{}, flag int) Handler {
 			c.Warn("postCreate error: %s", err)
 		}
 
-		// 触发器
-		_, err = act.Trigger(m)
-		if err != nil {
+		// 触发器, 出错则整个请求回滚
+		if _, err = act.Trigger(m); err != nil {
 			c.Warn("Trigger error: %s", err)
+			c.RESTError(err)
+			return
 		}
 		c.RESTOK(r)
 		return
@@ -518,20 +584,32 @@ func (rtr *Router) CRUD(i interface{}, flag int) Handler {
 
 		if _, err = act.PreUpdate(m); err != nil { // presearch准备条件等
 			c.Warn("PreUpdate error: %s", err)
-			c.RESTBadRequest(err)
+			if err == ErrNoRecord { //补丁找不到旧记录
+				c.RESTNotFound(err)
+			} else {
+				c.RESTBadRequest(err)
+			}
 			return
 		}
 
 		if _, err = act.OnUpdate(m); err != nil {
 			c.Warn("OnUpdate error: %s", err)
-			c.RESTNotOK(err)
+			if err == ErrPreconditionFailed { //版本号不符, 已经被别人修改
+				c.RESTGenericError(http.StatusPreconditionFailed, err)
+			} else {
+				c.RESTNotOK(err)
+			}
 			return
 		}
+		if etag := ModelETag(m); etag != "" {
+			c.SetHeader("ETag", etag)
+		}
 
-		// 触发器
-		_, err = act.Trigger(m)
-		if err != nil {
+		// 触发器, 出错则整个请求回滚
+		if _, err = act.Trigger(m); err != nil {
 			c.Warn("Trigger error: %s", err)
+			c.RESTError(err)
+			return
 		}
 
 		// update ok
@@ -544,8 +622,53 @@ func (rtr *Router) CRUD(i interface{}, flag int) Handler {
 		c.RESTOK(r)
 		return
 	}
-	//put := func(c *RESTContext) { //重置
-	//}
+	put := func(c *RESTContext) { //替换
+		m := NewModel(i.(Model), c)
+		defer act.Defer(m)
+		var err error
+
+		if _, err = act.PreReplace(m); err != nil {
+			c.Warn("PreReplace error: %s", err)
+			if err == ErrNoRecord {
+				c.RESTNotFound(err)
+			} else {
+				c.RESTBadRequest(err)
+			}
+			return
+		}
+
+		var r interface{}
+		if r, err = act.OnReplace(m); err != nil {
+			c.Warn("OnReplace error: %s", err)
+			if err == ErrNoRecord {
+				c.RESTNotFound(err)
+			} else if err == ErrPreconditionFailed {
+				c.RESTGenericError(http.StatusPreconditionFailed, err)
+			} else {
+				c.RESTNotOK(err)
+			}
+			return
+		}
+		m = r.(Model)
+		if etag := ModelETag(m); etag != "" {
+			c.SetHeader("ETag", etag)
+		}
+
+		// 触发器, 出错则整个请求回滚
+		if _, err = act.Trigger(m); err != nil {
+			c.Warn("Trigger error: %s", err)
+			c.RESTError(err)
+			return
+		}
+
+		if r, err = act.PostReplace(m); err != nil {
+			c.Warn("PostReplace error: %s", err)
+		}
+
+		c.AppLoggingResult(r)
+		c.RESTOK(r)
+		return
+	}
 	head := func(c *RESTContext) { //检查字段
 		m := NewModel(i.(Model), c)
 		defer act.Defer(m)
@@ -588,8 +711,8 @@ func (rtr *Router) CRUD(i interface{}, flag int) Handler {
 		return delete
 	case GA_PATCH:
 		return patch
-	//case GA_PUT:
-	//	return put
+	case GA_PUT:
+		return put
 	case GA_HEAD:
 		return head
 	default:
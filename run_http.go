@@ -13,8 +13,6 @@ import (
 	"path/filepath"
 	"runtime"
 
-	//"github.com/Odinman/ogo/bind"
-	//"github.com/Odinman/ogo/graceful"
 	"github.com/VividCortex/godaemon"
 	"github.com/nightlyone/lockfile"
 	"github.com/zenazn/goji"
@@ -38,12 +36,8 @@ func init() {
 	goji.Abandon(gojimiddle.Recoverer)
 	goji.Abandon(gojimiddle.AutomaticOptions)
 
-	//增加自定义的middleware
-	goji.Use(EnvInit)
-	goji.Use(Defer)
-	//goji.Use(Mime)
-	goji.Use(ParseHeaders)
-	goji.Use(ParseParams)
+	//增加自定义的middleware, 见useMiddlewares
+	ListenerMux(LISTENER_HTTP)
 
 	//mime
 	initMime()
@@ -56,6 +50,7 @@ func init() {
 	rr.AddRoute("POST", "/", rr.Post, RouteOption{KEY_SKIPLOGIN: true, KEY_SKIPAUTH: true})
 	rr.AddRoute("DELETE", "/", rr.Delete, RouteOption{KEY_SKIPLOGIN: true, KEY_SKIPAUTH: true})
 	rr.AddRoute("PATCH", "/", rr.Patch, RouteOption{KEY_SKIPLOGIN: true, KEY_SKIPAUTH: true})
+	rr.AddRoute("GET", OPENAPI_PATH, rr.OpenAPIDoc, RouteOption{KEY_SKIPLOGIN: true, KEY_SKIPAUTH: true})
 	rr.Init()
 }
 
@@ -77,9 +72,15 @@ func (mux *Mux) Run() {
 			fmt.Println("App crashed with error:", err)
 		}
 	}()
+	// SIGUSR2重启时继承的监听socket
+	ifs := inheritListeners()
 	if env.Daemonize {
 		//  for debug, CaptureOutput
-		stdOut, _, _ = godaemon.MakeDaemon(&godaemon.DaemonAttr{CaptureOutput: true})
+		attr := &godaemon.DaemonAttr{CaptureOutput: true}
+		if len(ifs) > 0 { //daemonize过程中保持打开
+			attr.Files = daemonFiles(ifs)
+		}
+		stdOut, _, _ = godaemon.MakeDaemon(attr)
 		go func(reader io.Reader) {
 			scanner := bufio.NewScanner(reader)
 			for scanner.Scan() {
@@ -116,18 +117,21 @@ func (mux *Mux) Run() {
 			}
 		}
 	}
-	if l, err := lockfile.New(env.PidFile); err == nil {
+	l, err := lockfile.New(env.PidFile)
+	if err != nil {
+		panic(err)
+	}
+	if len(ifs) == 0 { //重启时由新进程在旧进程退出后锁定
 		if le := l.TryLock(); le != nil {
 			panic(le)
 		}
-	} else {
-		panic(err)
 	}
 
-	Warn("Starting Ogo(http mode) on: %s", env.Port)
+	Warn("Starting Ogo(http mode)")
+	mux.routeTable() //路由都已注册, 生成OPTIONS/跨域用的路由表
 
-	// in goji appengine mode (tags --appengine)
-	goji.Serve()
+	// SIGTERM平滑关闭, SIGUSR2平滑重启, SIGHUP重新加载证书
+	mux.serve(ifs, l)
 }
 
 /* }}} */
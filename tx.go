@@ -0,0 +1,197 @@
+/* 事务, 按数据库tag延迟开启 */
+package ogo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/Odinman/gorp"
+)
+
+var (
+	ErrTxDone = errors.New("transaction has already been committed or rolled back")
+)
+
+// 事务, 同一个请求(或者WithTx)内的所有写操作共用
+type Tx struct {
+	lock sync.Mutex
+	txs  map[string]*gorp.Transaction
+	tags []string //开启顺序
+	done bool
+}
+
+/* {{{ func NewTx() *Tx
+ * 新建事务, 真正的数据库事务在第一次写的时候才开启
+ */
+func NewTx() *Tx {
+	return &Tx{txs: make(map[string]*gorp.Transaction)}
+}
+
+/* }}} */
+
+/* {{{ func WithTx(fn func(*Tx) error) (err error)
+ * 在事务中执行fn, 返回nil则提交, 出错或者panic则回滚
+ */
+func WithTx(fn func(*Tx) error) (err error) {
+	tx := NewTx()
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err = fn(tx); err != nil {
+		if re := tx.Rollback(); re != nil {
+			Warn("rollback failed: %s", re)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+/* }}} */
+
+/* {{{ func (tx *Tx) Executor(tag string) (gorp.SqlExecutor, error)
+ * 获取某个数据库的事务执行器, 没有则开启
+ */
+func (tx *Tx) Executor(tag string) (gorp.SqlExecutor, error) {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if t, ok := tx.txs[tag]; ok {
+		return t, nil
+	}
+	db := gorp.Using(tag)
+	if db == nil {
+		return nil, fmt.Errorf("not found db: %s", tag)
+	}
+	t, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	tx.txs[tag] = t
+	tx.tags = append(tx.tags, tag)
+	return t, nil
+}
+
+/* }}} */
+
+/* {{{ func (tx *Tx) opened(tag string) gorp.SqlExecutor
+ * 已经开启的某个数据库的事务, 没有则返回nil(不开启)
+ */
+func (tx *Tx) opened(tag string) gorp.SqlExecutor {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	if t, ok := tx.txs[tag]; ok && !tx.done {
+		return t
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (tx *Tx) Done() bool
+ * 是否已经提交或者回滚
+ */
+func (tx *Tx) Done() bool {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	return tx.done
+}
+
+/* }}} */
+
+/* {{{ func (tx *Tx) Started() bool
+ * 是否已经开启了数据库事务
+ */
+func (tx *Tx) Started() bool {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	return !tx.done && len(tx.tags) > 0
+}
+
+/* }}} */
+
+/* {{{ func (tx *Tx) Commit() error
+ * 提交所有数据库事务, 有一个失败则回滚剩下的
+ */
+func (tx *Tx) Commit() error {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	var err error
+	for _, tag := range tx.tags {
+		if err != nil {
+			tx.txs[tag].Rollback()
+			continue
+		}
+		if err = tx.txs[tag].Commit(); err != nil {
+			err = fmt.Errorf("commit %s failed: %s", tag, err)
+		}
+	}
+	return err
+}
+
+/* }}} */
+
+/* {{{ func (tx *Tx) Rollback() error
+ * 回滚所有数据库事务
+ */
+func (tx *Tx) Rollback() error {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	var err error
+	for _, tag := range tx.tags {
+		if e := tx.txs[tag].Rollback(); e != nil && err == nil {
+			err = fmt.Errorf("rollback %s failed: %s", tag, e)
+		}
+	}
+	return err
+}
+
+/* }}} */
+
+/* {{{ func (rc *RESTContext) Tx() *Tx
+ * 请求的事务, 请求成功时提交, 失败或者panic时回滚
+ * 已经结束(返回之后)则返回nil
+ */
+func (rc *RESTContext) Tx() *Tx {
+	if rc.tx == nil {
+		rc.tx = NewTx()
+	}
+	if rc.tx.Done() {
+		return nil
+	}
+	return rc.tx
+}
+
+/* }}} */
+
+/* {{{ func (rc *RESTContext) finishTx() error
+ * 根据返回码提交或者回滚请求事务, 只有提交失败才返回错误
+ */
+func (rc *RESTContext) finishTx() error {
+	if rc.tx == nil || rc.sharedTx || !rc.tx.Started() {
+		return nil
+	}
+	if rc.Status >= http.StatusBadRequest {
+		if err := rc.tx.Rollback(); err != nil {
+			rc.Warn("rollback failed: %s", err)
+		}
+		return nil
+	}
+	return rc.tx.Commit()
+}
+
+/* }}} */
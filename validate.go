@@ -0,0 +1,229 @@
+/* 声明式字段校验, 规则写在filter tag的选项里
+ * 例: `filter:",R,min=1,max=100"`, `filter:",len<=64,email"`, `filter:",enum=a|b|c"`, `filter:",regex=^[a-z]{2,8}$"`
+ * regex=之后到结尾都是表达式(可以包含逗号), 所以必须放在最后
+ */
+package ogo
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Odinman/ogo/utils"
+)
+
+const (
+	RULE_MIN   = "min"   // 数字的最小值, 字符串的最小长度
+	RULE_MAX   = "max"   // 数字的最大值, 字符串的最大长度
+	RULE_LEN   = "len"   // 字符串长度, len=8, len<=64, len>=2, len<64, len>2
+	RULE_ENUM  = "enum"  // 枚举, enum=a|b|c
+	RULE_EMAIL = "email" // 邮箱
+	RULE_REGEX = "regex" // 正则, 必须放在最后
+)
+
+var (
+	regexCache = utils.NewSafeMap() // 编译好的正则, 表达式 => *regexp.Regexp
+	emailRegex = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s.]+$`)
+	lenOps     = []string{"<=", ">=", "<", ">", "="}
+)
+
+// 校验规则
+type rule struct {
+	Name string
+	Op   string // len的比较符, 其余为"="
+	Arg  string
+}
+
+/* {{{ func parseRules(o utils.TagOptions) []rule
+ * 从filter tag选项中解析校验规则, 不认识的选项忽略(R/C/O等)
+ */
+func parseRules(o utils.TagOptions) []rule {
+	rules := make([]rule, 0)
+	s := string(o)
+	for s != "" {
+		opt := s
+		if strings.HasPrefix(s, RULE_REGEX+"=") { //正则到结尾
+			s = ""
+		} else if i := strings.Index(s, ","); i >= 0 {
+			opt, s = s[:i], s[i+1:]
+		} else {
+			s = ""
+		}
+		switch {
+		case opt == RULE_EMAIL:
+			rules = append(rules, rule{Name: RULE_EMAIL})
+		case strings.HasPrefix(opt, RULE_LEN):
+			for _, op := range lenOps {
+				if strings.HasPrefix(opt[len(RULE_LEN):], op) {
+					rules = append(rules, rule{Name: RULE_LEN, Op: op, Arg: opt[len(RULE_LEN)+len(op):]})
+					break
+				}
+			}
+		default:
+			if i := strings.Index(opt, "="); i > 0 {
+				switch name := opt[:i]; name {
+				case RULE_MIN, RULE_MAX, RULE_ENUM, RULE_REGEX:
+					rules = append(rules, rule{Name: name, Op: "=", Arg: opt[i+1:]})
+				}
+			}
+		}
+	}
+	return rules
+}
+
+/* }}} */
+
+/* {{{ func getRegex(expr string) (*regexp.Regexp, error)
+ * 正则编译一次之后缓存
+ */
+func getRegex(expr string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Get(expr).(*regexp.Regexp); ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexCache.Set(expr, re)
+	return re, nil
+}
+
+/* }}} */
+
+/* {{{ func compareLen(l int, op string, n int) bool
+ *
+ */
+func compareLen(l int, op string, n int) bool {
+	switch op {
+	case "<=":
+		return l <= n
+	case ">=":
+		return l >= n
+	case "<":
+		return l < n
+	case ">":
+		return l > n
+	default:
+		return l == n
+	}
+}
+
+/* }}} */
+
+/* {{{ func (r rule) check(fv reflect.Value) string
+ * 校验一个非空的值, 通过返回空字符串, 否则返回错误信息
+ */
+func (r rule) check(fv reflect.Value) string {
+	for fv.Kind() == reflect.Ptr {
+		fv = fv.Elem()
+	}
+	var num float64
+	isNum, isStr := true, false
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		num = float64(fv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		num = float64(fv.Uint())
+	case reflect.Float32, reflect.Float64:
+		num = fv.Float()
+	case reflect.String:
+		isNum, isStr = false, true
+	default:
+		isNum = false
+	}
+	str := fmt.Sprint(fv.Interface())
+	switch r.Name {
+	case RULE_MIN, RULE_MAX:
+		limit, err := strconv.ParseFloat(r.Arg, 64)
+		if err != nil {
+			return fmt.Sprintf("invalid rule: %s=%s", r.Name, r.Arg)
+		}
+		if isStr { //字符串比较长度
+			num = float64(utf8.RuneCountInString(str))
+		} else if !isNum {
+			return ""
+		}
+		if r.Name == RULE_MIN && num < limit {
+			if isStr {
+				return fmt.Sprintf("length must be at least %s", r.Arg)
+			}
+			return fmt.Sprintf("must be at least %s", r.Arg)
+		} else if r.Name == RULE_MAX && num > limit {
+			if isStr {
+				return fmt.Sprintf("length must be at most %s", r.Arg)
+			}
+			return fmt.Sprintf("must be at most %s", r.Arg)
+		}
+	case RULE_LEN:
+		n, err := strconv.Atoi(r.Arg)
+		if err != nil {
+			return fmt.Sprintf("invalid rule: len%s%s", r.Op, r.Arg)
+		}
+		if !compareLen(utf8.RuneCountInString(str), r.Op, n) {
+			return fmt.Sprintf("length must be %s %d", r.Op, n)
+		}
+	case RULE_ENUM:
+		if !utils.InSlice(str, strings.Split(r.Arg, "|")) {
+			return fmt.Sprintf("must be one of %s", strings.Replace(r.Arg, "|", ", ", -1))
+		}
+	case RULE_EMAIL:
+		if !emailRegex.MatchString(str) {
+			return "invalid email"
+		}
+	case RULE_REGEX:
+		re, err := getRegex(r.Arg)
+		if err != nil {
+			return fmt.Sprintf("invalid rule: regex=%s", r.Arg)
+		}
+		if !re.MatchString(str) {
+			return fmt.Sprintf("must match %s", r.Arg)
+		}
+	}
+	return ""
+}
+
+/* }}} */
+
+/* {{{ func checkRules(fv reflect.Value, o utils.TagOptions) string
+ * 依次校验所有规则, 返回第一个错误, nil指针不校验(由R负责), 零值照样校验
+ */
+func checkRules(fv reflect.Value, o utils.TagOptions) string {
+	for fv.IsValid() && fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return ""
+		}
+		fv = fv.Elem()
+	}
+	if !fv.IsValid() {
+		return ""
+	}
+	for _, r := range parseRules(o) {
+		if msg := r.check(fv); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+/* }}} */
+
+/* {{{ func jsonName(t reflect.Type, col utils.StructColumn) string
+ * 字段对外(json)的名称, 没有json tag则用db名
+ */
+func jsonName(t reflect.Type, col utils.StructColumn) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return col.Tag
+	}
+	if name, _ := utils.ParseTag(t.FieldByIndex(col.Index).Tag.Get("json")); name != "" && name != "-" {
+		return name
+	}
+	return col.Tag
+}
+
+/* }}} */
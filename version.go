@@ -0,0 +1,206 @@
+/* 乐观锁, filter tag为"ver"的字段作为版本号 */
+package ogo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Odinman/gorp"
+	"github.com/Odinman/ogo/utils"
+)
+
+const (
+	EXTTAG_VERSION = "ver" //版本号字段, 创建时为1, 每次更新加1
+)
+
+var (
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+/* {{{ func versionColumn(m Model) (utils.StructColumn, bool)
+ * 版本号字段
+ */
+func versionColumn(m Model) (utils.StructColumn, bool) {
+	for _, col := range utils.ReadStructColumns(m, true) {
+		if col.ExtTag == EXTTAG_VERSION {
+			return col, true
+		}
+	}
+	return utils.StructColumn{}, false
+}
+
+/* }}} */
+
+/* {{{ func getVersion(fv reflect.Value) (int64, bool)
+ * 读取版本号, 为空返回false
+ */
+func getVersion(fv reflect.Value) (int64, bool) {
+	for fv.IsValid() && fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return 0, false
+		}
+		fv = fv.Elem()
+	}
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fv.Int(), fv.Int() > 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(fv.Uint()), fv.Uint() > 0
+	}
+	return 0, false
+}
+
+/* }}} */
+
+/* {{{ func setVersion(fv reflect.Value, ver int64) error
+ * 设置版本号, 支持整数以及整数指针
+ */
+func setVersion(fv reflect.Value, ver int64) error {
+	if !fv.IsValid() || !fv.CanSet() {
+		return fmt.Errorf("version field can't be set")
+	}
+	t := fv.Type()
+	et := t
+	if t.Kind() == reflect.Ptr {
+		et = t.Elem()
+	}
+	nv := reflect.New(et).Elem()
+	switch et.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		nv.SetInt(ver)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		nv.SetUint(uint64(ver))
+	default:
+		return fmt.Errorf("version field must be integer, not %s", et.Kind().String())
+	}
+	if t.Kind() == reflect.Ptr {
+		pv := reflect.New(et)
+		pv.Elem().Set(nv)
+		fv.Set(pv)
+	} else {
+		fv.Set(nv)
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func ModelETag(m Model) string
+ * 有版本号的model, ETag为版本号
+ */
+func ModelETag(m Model) string {
+	if col, ok := versionColumn(m); ok {
+		if ver, ok := getVersion(utils.FieldByIndex(reflect.ValueOf(m), col.Index)); ok {
+			return strconv.Quote(strconv.FormatInt(ver, 10))
+		}
+	}
+	return ""
+}
+
+/* }}} */
+
+/* {{{ func parseETags(h string) []string
+ * If-Match/If-None-Match里的etag列表, 去掉W/
+ */
+func parseETags(h string) []string {
+	tags := make([]string, 0)
+	for _, t := range strings.Split(h, ",") {
+		if t = strings.TrimPrefix(strings.TrimSpace(t), "W/"); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+/* }}} */
+
+/* {{{ func MatchETag(h, etag string) bool
+ * etag是否满足If-Match, "*"匹配任何存在的记录
+ */
+func MatchETag(h, etag string) bool {
+	for _, t := range parseETags(h) {
+		if t == "*" && etag != "" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+/* }}} */
+
+/* {{{ func matchVersion(h, etag string) bool
+ * If-Match只比较版本号, 带fields/expand摘要的ETag(见variantETag)也可以用
+ */
+func matchVersion(h, etag string) bool {
+	for _, t := range parseETags(h) {
+		if t == "*" && etag != "" || etagVersion(t) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+/* }}} */
+
+/* {{{ func etagVersion(t string) string
+ * 去掉ETag中"-"之后的摘要
+ */
+func etagVersion(t string) string {
+	if i := strings.LastIndex(t, "-"); i > 0 && strings.HasSuffix(t, `"`) {
+		return t[:i] + `"`
+	}
+	return t
+}
+
+/* }}} */
+
+/* {{{ func (bm *BaseModel) checkVersion(db gorp.SqlExecutor, id string) error
+ * 更新前占用版本号: UPDATE t SET ver=ver+1 WHERE pk=? AND ver=?, 没有更新则说明已被别人修改
+ * 期望的版本号来自If-Match, 没有则来自传入的内容, 都没有则为当前版本; 没有更新一律返回ErrPreconditionFailed
+ */
+func (bm *BaseModel) checkVersion(db gorp.SqlExecutor, id string) error {
+	m := bm.GetModel()
+	col, ok := versionColumn(m)
+	if !ok {
+		return nil
+	}
+	fv := utils.FieldByIndex(reflect.ValueOf(m), col.Index)
+	d := GetDialect(bm.dbTag(WRITETAG))
+	pf, _, _ := m.PKey()
+	tb, vc := d.Quote(m.TableName()), d.Quote(col.Tag)
+	currentVersion := func() (int64, error) {
+		return db.SelectInt(Rebind(d, fmt.Sprintf("SELECT COALESCE(%s, 0) FROM %s WHERE %s = ?", vc, tb, d.Quote(pf))), id)
+	}
+	expected, ok := getVersion(fv)
+	if c := m.GetCtx(); c != nil {
+		if im, _ := c.GetEnv(IfMatchKey).(string); im != "" {
+			current, err := currentVersion()
+			if err != nil {
+				return err
+			}
+			if !matchVersion(im, strconv.Quote(strconv.FormatInt(current, 10))) {
+				return ErrPreconditionFailed
+			}
+			expected, ok = current, true
+		}
+	}
+	if !ok { //没有前提条件, 以当前版本为准
+		current, err := currentVersion()
+		if err != nil {
+			return err
+		}
+		expected = current
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s = COALESCE(%s, 0) + 1 WHERE %s = ? AND COALESCE(%s, 0) = ?", tb, vc, vc, d.Quote(pf), vc)
+	if r, err := db.Exec(Rebind(d, query), id, expected); err != nil {
+		return err
+	} else if affected, _ := r.RowsAffected(); affected == 0 { //读取之后被别人修改(或删除), 不能覆盖
+		return ErrPreconditionFailed
+	}
+	return setVersion(fv, expected+1)
+}
+
+/* }}} */